@@ -0,0 +1,185 @@
+package msgpack
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file contains functions that append msgpack encoded values to
+// a byte slice, in the spirit of strconv.AppendInt and friends. They
+// produce the same bytes as the corresponding Encoder methods, but do
+// not allocate unless dst needs to grow.
+//
+// Since these functions do not return errors, they panic if they are
+// given a length that can not be represented in msgpack (i.e. negative,
+// or more than math.MaxUint32 bytes or elements)
+
+func appendUint16(dst []byte, v uint16) []byte {
+	return append(dst, byte(v>>8), byte(v))
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	return append(dst, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	return append(dst, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendByteUint8(dst []byte, b byte, v uint8) []byte {
+	return append(dst, b, byte(v))
+}
+
+func appendByteUint16(dst []byte, b byte, v uint16) []byte {
+	return appendUint16(append(dst, b), v)
+}
+
+func appendByteUint32(dst []byte, b byte, v uint32) []byte {
+	return appendUint32(append(dst, b), v)
+}
+
+func appendByteUint64(dst []byte, b byte, v uint64) []byte {
+	return appendUint64(append(dst, b), v)
+}
+
+func checkAppendLength(l int, what string) {
+	if l < 0 {
+		panic(errors.Errorf(`msgpack: invalid %s length %d`, what, l))
+	}
+	if uint64(l) > math.MaxUint32 {
+		panic(errors.Errorf(`msgpack: %s is too long (len=%d)`, what, l))
+	}
+}
+
+// AppendNil appends a nil value to dst
+func AppendNil(dst []byte) []byte {
+	return append(dst, Nil.Byte())
+}
+
+// AppendBool appends a boolean value to dst
+func AppendBool(dst []byte, b bool) []byte {
+	if b {
+		return append(dst, True.Byte())
+	}
+	return append(dst, False.Byte())
+}
+
+// AppendString appends s to dst as a msgpack str
+func AppendString(dst []byte, s string) []byte {
+	dst = appendStringHeader(dst, len(s))
+	return append(dst, s...)
+}
+
+// AppendStringBytes appends b to dst as a msgpack str. This is useful
+// to avoid converting []byte to string
+func AppendStringBytes(dst []byte, b []byte) []byte {
+	dst = appendStringHeader(dst, len(b))
+	return append(dst, b...)
+}
+
+func appendStringHeader(dst []byte, l int) []byte {
+	switch {
+	case l < 32:
+		return append(dst, FixStr0.Byte()|uint8(l))
+	case l <= math.MaxUint8:
+		return appendByteUint8(dst, Str8.Byte(), uint8(l))
+	case l <= math.MaxUint16:
+		return appendByteUint16(dst, Str16.Byte(), uint16(l))
+	}
+	checkAppendLength(l, `string`)
+	return appendByteUint32(dst, Str32.Byte(), uint32(l))
+}
+
+// AppendBytes appends b to dst as a msgpack bin
+func AppendBytes(dst []byte, b []byte) []byte {
+	l := len(b)
+	switch {
+	case l <= math.MaxUint8:
+		dst = appendByteUint8(dst, Bin8.Byte(), uint8(l))
+	case l <= math.MaxUint16:
+		dst = appendByteUint16(dst, Bin16.Byte(), uint16(l))
+	default:
+		checkAppendLength(l, `[]byte`)
+		dst = appendByteUint32(dst, Bin32.Byte(), uint32(l))
+	}
+	return append(dst, b...)
+}
+
+// AppendArrayHeader appends the header for an array of c elements
+// to dst. The elements must be appended after the header
+func AppendArrayHeader(dst []byte, c int) []byte {
+	checkAppendLength(c, `array`)
+	switch {
+	case c < 16:
+		return append(dst, FixArray0.Byte()+byte(c))
+	case c <= math.MaxUint16:
+		return appendByteUint16(dst, Array16.Byte(), uint16(c))
+	}
+	return appendByteUint32(dst, Array32.Byte(), uint32(c))
+}
+
+// AppendMapHeader appends the header for a map of c key/value pairs
+// to dst. The keys and values must be appended after the header
+func AppendMapHeader(dst []byte, c int) []byte {
+	checkAppendLength(c, `map`)
+	switch {
+	case c < 16:
+		return append(dst, FixMap0.Byte()+byte(c))
+	case c <= math.MaxUint16:
+		return appendByteUint16(dst, Map16.Byte(), uint16(c))
+	}
+	return appendByteUint32(dst, Map32.Byte(), uint32(c))
+}
+
+// AppendExtHeader appends the header for an extension of type typ,
+// with a payload of l bytes. The payload must be appended after the header
+func AppendExtHeader(dst []byte, typ int8, l int) []byte {
+	checkAppendLength(l, `extension payload`)
+	switch {
+	case l == 1:
+		dst = append(dst, FixExt1.Byte())
+	case l == 2:
+		dst = append(dst, FixExt2.Byte())
+	case l == 4:
+		dst = append(dst, FixExt4.Byte())
+	case l == 8:
+		dst = append(dst, FixExt8.Byte())
+	case l == 16:
+		dst = append(dst, FixExt16.Byte())
+	case l <= math.MaxUint8:
+		dst = appendByteUint8(dst, Ext8.Byte(), uint8(l))
+	case l <= math.MaxUint16:
+		dst = appendByteUint16(dst, Ext16.Byte(), uint16(l))
+	default:
+		dst = appendByteUint32(dst, Ext32.Byte(), uint32(l))
+	}
+	return append(dst, byte(typ))
+}
+
+// AppendExt appends an extension of type typ with the given payload to dst
+func AppendExt(dst []byte, typ int8, payload []byte) []byte {
+	dst = AppendExtHeader(dst, typ, len(payload))
+	return append(dst, payload...)
+}
+
+// AppendTime appends t to dst using the timestamp extension, using
+// the same format as Encoder.EncodeTime
+func AppendTime(dst []byte, t time.Time) []byte {
+	sec := t.Unix()
+	nsec := t.Nanosecond()
+
+	switch {
+	case sec>>32 == 0 && nsec == 0:
+		dst = appendByteUint8(dst, FixExt4.Byte(), timestampExtByte)
+		return appendUint32(dst, uint32(sec))
+	case sec>>34 == 0:
+		dst = appendByteUint8(dst, FixExt8.Byte(), timestampExtByte)
+		return appendUint64(dst, uint64(nsec)<<34|uint64(sec))
+	default:
+		dst = append(dst, Ext8.Byte(), 12, timestampExtByte)
+		dst = appendUint32(dst, uint32(nsec))
+		return appendUint64(dst, uint64(sec))
+	}
+}
@@ -0,0 +1,149 @@
+package msgpack_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+type appendExt struct{}
+
+func (appendExt) EncodeMsgpack(e msgpack.Encoder) error {
+	_, err := e.Writer().Write([]byte("payload"))
+	return err
+}
+
+func (*appendExt) DecodeMsgpack(d msgpack.Decoder) error {
+	return nil
+}
+
+func init() {
+	if err := msgpack.RegisterExt(42, appendExt{}); err != nil {
+		panic(err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	t.Parallel()
+
+	longString := strings.Repeat("x", math.MaxUint16+1)
+	testcases := []struct {
+		Name     string
+		Append   func([]byte) []byte
+		Expected interface{}
+	}{
+		{Name: "Nil", Append: msgpack.AppendNil, Expected: nil},
+		{Name: "Bool", Append: func(b []byte) []byte { return msgpack.AppendBool(b, true) }, Expected: true},
+		{Name: "Int", Append: func(b []byte) []byte { return msgpack.AppendInt(b, -5) }, Expected: int(-5)},
+		{Name: "Int8", Append: func(b []byte) []byte { return msgpack.AppendInt8(b, -100) }, Expected: int8(-100)},
+		{Name: "Int16", Append: func(b []byte) []byte { return msgpack.AppendInt16(b, 1000) }, Expected: int16(1000)},
+		{Name: "Int32", Append: func(b []byte) []byte { return msgpack.AppendInt32(b, math.MinInt32) }, Expected: int32(math.MinInt32)},
+		{Name: "Int64", Append: func(b []byte) []byte { return msgpack.AppendInt64(b, math.MaxInt64) }, Expected: int64(math.MaxInt64)},
+		{Name: "Uint", Append: func(b []byte) []byte { return msgpack.AppendUint(b, 5) }, Expected: uint(5)},
+		{Name: "Uint8", Append: func(b []byte) []byte { return msgpack.AppendUint8(b, 200) }, Expected: uint8(200)},
+		{Name: "Uint16", Append: func(b []byte) []byte { return msgpack.AppendUint16(b, 1000) }, Expected: uint16(1000)},
+		{Name: "Uint32", Append: func(b []byte) []byte { return msgpack.AppendUint32(b, math.MaxUint32) }, Expected: uint32(math.MaxUint32)},
+		{Name: "Uint64", Append: func(b []byte) []byte { return msgpack.AppendUint64(b, math.MaxUint64) }, Expected: uint64(math.MaxUint64)},
+		{Name: "Float32", Append: func(b []byte) []byte { return msgpack.AppendFloat32(b, 1.5) }, Expected: float32(1.5)},
+		{Name: "Float64", Append: func(b []byte) []byte { return msgpack.AppendFloat64(b, -1.5) }, Expected: float64(-1.5)},
+		{Name: "FixStr", Append: func(b []byte) []byte { return msgpack.AppendString(b, "Hello") }, Expected: "Hello"},
+		{Name: "Str16", Append: func(b []byte) []byte { return msgpack.AppendString(b, longString[:300]) }, Expected: longString[:300]},
+		{Name: "Str32", Append: func(b []byte) []byte { return msgpack.AppendStringBytes(b, []byte(longString)) }, Expected: longString},
+		{Name: "Bin8", Append: func(b []byte) []byte { return msgpack.AppendBytes(b, []byte("Hello")) }, Expected: []byte("Hello")},
+		{Name: "Bin32", Append: func(b []byte) []byte { return msgpack.AppendBytes(b, []byte(longString)) }, Expected: []byte(longString)},
+		{Name: "Time32", Append: func(b []byte) []byte { return msgpack.AppendTime(b, time.Unix(1000, 0)) }, Expected: time.Unix(1000, 0)},
+		{Name: "Time64", Append: func(b []byte) []byte { return msgpack.AppendTime(b, time.Unix(1000, 1)) }, Expected: time.Unix(1000, 1)},
+		{Name: "Time96", Append: func(b []byte) []byte { return msgpack.AppendTime(b, time.Unix(-1000, 1)) }, Expected: time.Unix(-1000, 1)},
+		{Name: "Ext", Append: func(b []byte) []byte { return msgpack.AppendExt(b, 42, []byte("payload")) }, Expected: appendExt{}},
+		{
+			Name: "Array",
+			Append: func(b []byte) []byte {
+				b = msgpack.AppendArrayHeader(b, 2)
+				b = msgpack.AppendString(b, "foo")
+				return msgpack.AppendBool(b, false)
+			},
+			Expected: []interface{}{"foo", false},
+		},
+		{
+			Name: "Map",
+			Append: func(b []byte) []byte {
+				b = msgpack.AppendMapHeader(b, 1)
+				b = msgpack.AppendString(b, "foo")
+				return msgpack.AppendNil(b)
+			},
+			Expected: map[string]interface{}{"foo": nil},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			expected, err := msgpack.Marshal(tc.Expected)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+
+			prefix := []byte("prefix")
+			b := tc.Append(prefix)
+			assert.Equal(t, "prefix", string(b[:len(prefix)]), "prefix should be preserved")
+			assert.Equal(t, expected, b[len(prefix):], "appended bytes should match Marshal")
+		})
+	}
+}
+
+func TestAppendInvalidLength(t *testing.T) {
+	t.Parallel()
+
+	assert.Panics(t, func() { msgpack.AppendArrayHeader(nil, -1) }, "AppendArrayHeader should panic on negative counts")
+	assert.Panics(t, func() { msgpack.AppendMapHeader(nil, -1) }, "AppendMapHeader should panic on negative counts")
+	assert.Panics(t, func() { msgpack.AppendExtHeader(nil, 42, -1) }, "AppendExtHeader should panic on negative lengths")
+}
+
+func TestMarshalAppend(t *testing.T) {
+	t.Parallel()
+
+	v := map[string]interface{}{"foo": []int{1, 2, 3}}
+	expected, err := msgpack.Marshal(v)
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+
+	dst := make([]byte, 0, 64)
+	dst = append(dst, "prefix"...)
+	b, err := msgpack.MarshalAppend(dst, v)
+	if !assert.NoError(t, err, "MarshalAppend should succeed") {
+		return
+	}
+	assert.Equal(t, append([]byte("prefix"), expected...), b, "result should match")
+	assert.Equal(t, &dst[:1][0], &b[0], "dst should be reused if it has enough capacity")
+
+	_, err = msgpack.MarshalAppend(dst, v, msgpack.WithStructAsArray(true))
+	assert.NoError(t, err, "MarshalAppend with options should succeed")
+
+	b, err = msgpack.MarshalAppend(dst, make(chan int))
+	assert.Error(t, err, "MarshalAppend should fail")
+	assert.Equal(t, dst, b, "dst should be returned on error")
+}
+
+// Note: AllocsPerRun cannot be used in parallel tests
+func TestAppendAllocs(t *testing.T) {
+	buf := make([]byte, 0, 1024)
+	now := time.Now()
+	allocs := testing.AllocsPerRun(100, func() {
+		b := msgpack.AppendMapHeader(buf[:0], 3)
+		b = msgpack.AppendString(b, "int")
+		b = msgpack.AppendInt64(b, -12345)
+		b = msgpack.AppendString(b, "list")
+		b = msgpack.AppendArrayHeader(b, 2)
+		b = msgpack.AppendFloat64(b, 3.14)
+		b = msgpack.AppendBytes(b, []byte("bytes"))
+		b = msgpack.AppendString(b, "time")
+		msgpack.AppendTime(b, now)
+	})
+	assert.Zero(t, allocs, "Append functions should not allocate")
+}
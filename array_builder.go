@@ -33,14 +33,14 @@ func WriteArrayHeader(dst io.Writer, c int) error {
 		if err := w.WriteByte(FixArray0.Byte() + byte(c)); err != nil {
 			return errors.Wrap(err, `msgpack: failed to write fixed array header`)
 		}
-	case c < math.MaxUint16:
+	case c <= math.MaxUint16:
 		if err := w.WriteByte(Array16.Byte()); err != nil {
 			return errors.Wrap(err, `msgpack: failed to write 16-bit array header prefix`)
 		}
 		if err := w.WriteUint16(uint16(c)); err != nil {
 			return errors.Wrap(err, `msgpack: failed to write 16-bit array header`)
 		}
-	case c < math.MaxUint32:
+	case c <= math.MaxUint32:
 		if err := w.WriteByte(Array32.Byte()); err != nil {
 			return errors.Wrap(err, `msgpack: failed to write 32-bit array header prefix`)
 		}
@@ -64,6 +64,9 @@ func (e arrayBuilder) Encode(dst io.Writer) error {
 			return errors.Wrapf(err, `msgpack: failed to encode array element %s`, reflect.TypeOf(v))
 		}
 	}
+	if err := enc.Flush(); err != nil {
+		return errors.Wrap(err, `msgpack: failed to flush array elements`)
+	}
 	return nil
 }
 
@@ -1135,3 +1135,51 @@ func BenchmarkDecodeMap(b *testing.B) {
 		}
 	}
 }
+
+type benchStruct struct {
+	Name    string
+	Count   int
+	Ratio   float64
+	Enabled bool
+	Tags    []string
+}
+
+var benchStructValue = benchStruct{
+	Name:    "Hello, World!",
+	Count:   math.MaxUint16,
+	Ratio:   math.Pi,
+	Enabled: true,
+	Tags:    []string{"uno", "dos", "tres"},
+}
+
+func BenchmarkEncodeStruct(b *testing.B) {
+	for _, data := range encoders {
+		if enc, ok := data.Encoder.(Encoder); ok {
+			b.Run(fmt.Sprintf("%s/struct via Encode()", data.Name), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := enc.Encode(benchStructValue); err != nil {
+						panic(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDecodeStruct(b *testing.B) {
+	serialized, _ := lestrrat.Marshal(benchStructValue)
+	for _, data := range encoders {
+		rdr := NewPatternReader(serialized)
+		canary := data.MakeDecoder(rdr)
+		if dec, ok := canary.(Decoder); ok {
+			b.Run(fmt.Sprintf("%s/struct via Decode()", data.Name), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					var v benchStruct
+					if err := dec.Decode(&v); err != nil {
+						panic(err)
+					}
+				}
+			})
+		}
+	}
+}
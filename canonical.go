@@ -0,0 +1,394 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The canonical form of a msgpack value is defined as follows:
+//
+//   * Integers use the smallest possible representation. Non-negative
+//     integers always use the unsigned family (positive fixnum, uint8,
+//     uint16, ...), and negative integers use the signed family
+//     (negative fixnum, int8, int16, ...)
+//   * Floating point numbers that can be represented exactly as a
+//     float32 are encoded as float32. All NaNs are encoded as the same
+//     float32 quiet NaN, and negative zero is encoded as positive zero
+//   * str, bin, array, map and ext headers use the smallest possible
+//     representation
+//   * Map elements are sorted by the bytes of their canonical keys
+//     (and by their values, in the unlikely event that the keys are equal)
+//   * Timestamps use the smallest of the timestamp 32, 64 and 96 formats
+//
+// Payloads of extensions other than timestamps are opaque, and are
+// therefore left as-is
+
+// canonicalNaN is the bit pattern used for NaN in canonical form
+const canonicalNaN = uint32(0x7fc00000)
+
+func appendCanonicalInt(dst []byte, v int64) []byte {
+	if v >= 0 {
+		return appendCanonicalUint(dst, uint64(v))
+	}
+
+	switch {
+	case v >= -32:
+		return append(dst, byte(v))
+	case v >= math.MinInt8:
+		return appendByteUint8(dst, Int8.Byte(), uint8(v))
+	case v >= math.MinInt16:
+		return appendByteUint16(dst, Int16.Byte(), uint16(v))
+	case v >= math.MinInt32:
+		return appendByteUint32(dst, Int32.Byte(), uint32(v))
+	}
+	return appendByteUint64(dst, Int64.Byte(), uint64(v))
+}
+
+func appendCanonicalUint(dst []byte, v uint64) []byte {
+	switch {
+	case v <= uint64(MaxPositiveFixNum):
+		return append(dst, byte(v))
+	case v <= math.MaxUint8:
+		return appendByteUint8(dst, Uint8.Byte(), uint8(v))
+	case v <= math.MaxUint16:
+		return appendByteUint16(dst, Uint16.Byte(), uint16(v))
+	case v <= math.MaxUint32:
+		return appendByteUint32(dst, Uint32.Byte(), uint32(v))
+	}
+	return appendByteUint64(dst, Uint64.Byte(), v)
+}
+
+func appendCanonicalFloat(dst []byte, f float64) []byte {
+	if math.IsNaN(f) {
+		return appendByteUint32(dst, Float.Byte(), canonicalNaN)
+	}
+	if f == 0 {
+		// -0.0 == 0.0, so drop the sign bit
+		f = 0
+	}
+	if f32 := float32(f); float64(f32) == f {
+		return appendByteUint32(dst, Float.Byte(), math.Float32bits(f32))
+	}
+	return appendByteUint64(dst, Double.Byte(), math.Float64bits(f))
+}
+
+// writeCanonical writes a value that has been prepared using one of
+// the appendCanonicalXXX functions
+func (enl *encoderNL) writeCanonical(b []byte) error {
+	if _, err := enl.dst.Write(b); err != nil {
+		return errors.Wrap(err, `msgpack: failed to write canonical value`)
+	}
+	return nil
+}
+
+func (enl *encoderNL) encodeCanonicalNumber(n Number) error {
+	if n.IsFloat() {
+		f, _ := n.Float64()
+		return enl.writeCanonical(appendCanonicalFloat(enl.scratch[:0], f))
+	}
+	if i, err := n.Int64(); err == nil {
+		return enl.writeCanonical(appendCanonicalInt(enl.scratch[:0], i))
+	}
+	u, _ := n.Uint64()
+	return enl.writeCanonical(appendCanonicalUint(enl.scratch[:0], u))
+}
+
+// canonicalEntry is a map element in canonical form. The key and the
+// value are stored as offsets into a shared buffer
+type canonicalEntry struct {
+	keyStart, keyEnd, valueEnd int
+}
+
+// sortCanonicalEntries sorts map elements whose keys and values have
+// been written to buf by the bytes of their keys, and then values
+func sortCanonicalEntries(buf []byte, entries []canonicalEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if c := bytes.Compare(buf[a.keyStart:a.keyEnd], buf[b.keyStart:b.keyEnd]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(buf[a.keyEnd:a.valueEnd], buf[b.keyEnd:b.valueEnd]) < 0
+	})
+}
+
+// Canonicalize rewrites the msgpack payload data into canonical form,
+// such that payloads that represent equal values are byte-identical.
+// The result is the same as if the values had been encoded using an
+// Encoder with the WithCanonical option. If data contains multiple
+// values, each of them is rewritten
+func Canonicalize(data []byte) ([]byte, error) {
+	c := canonicalizer{src: newSliceReader(data)}
+
+	out := make([]byte, 0, len(data))
+	for c.src.pos < len(data) {
+		var err error
+		out, err = c.appendValue(out, 1)
+		if err != nil {
+			return nil, errors.Wrap(err, `msgpack: failed to canonicalize payload`)
+		}
+	}
+	return out, nil
+}
+
+type canonicalizer struct {
+	src *sliceReader
+}
+
+func (c *canonicalizer) appendValue(dst []byte, depth int) ([]byte, error) {
+	if depth > DefaultMaxDepth {
+		return nil, &LimitError{Kind: LimitDepth, Limit: DefaultMaxDepth, Value: int64(depth)}
+	}
+
+	b, err := c.src.ReadByte()
+	if err != nil {
+		return nil, errors.Wrap(err, `msgpack: failed to read code`)
+	}
+	code := Code(b)
+
+	switch {
+	case IsFixNumFamily(code) || code == Nil || code == True || code == False:
+		// These are already as small as they can get
+		return append(dst, b), nil
+	case code == Int8:
+		v, err := c.src.ReadUint8()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalInt(dst, int64(int8(v))), nil
+	case code == Int16:
+		v, err := c.src.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalInt(dst, int64(int16(v))), nil
+	case code == Int32:
+		v, err := c.src.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalInt(dst, int64(int32(v))), nil
+	case code == Int64:
+		v, err := c.src.ReadUint64()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalInt(dst, int64(v)), nil
+	case code == Uint8:
+		v, err := c.src.ReadUint8()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalUint(dst, uint64(v)), nil
+	case code == Uint16:
+		v, err := c.src.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalUint(dst, uint64(v)), nil
+	case code == Uint32:
+		v, err := c.src.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalUint(dst, uint64(v)), nil
+	case code == Uint64:
+		v, err := c.src.ReadUint64()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalUint(dst, v), nil
+	case code == Float:
+		v, err := c.src.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalFloat(dst, float64(math.Float32frombits(v))), nil
+	case code == Double:
+		v, err := c.src.ReadUint64()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalFloat(dst, math.Float64frombits(v)), nil
+	case IsStrFamily(code):
+		payload, err := c.readPayload(code)
+		if err != nil {
+			return nil, err
+		}
+		return AppendStringBytes(dst, payload), nil
+	case IsBinFamily(code):
+		payload, err := c.readPayload(code)
+		if err != nil {
+			return nil, err
+		}
+		return AppendBytes(dst, payload), nil
+	case IsArrayFamily(code):
+		l, err := c.readCount(code)
+		if err != nil {
+			return nil, err
+		}
+		dst = AppendArrayHeader(dst, l)
+		for i := 0; i < l; i++ {
+			if dst, err = c.appendValue(dst, depth+1); err != nil {
+				return nil, errors.Wrapf(err, `msgpack: failed to canonicalize array element %d`, i)
+			}
+		}
+		return dst, nil
+	case IsMapFamily(code):
+		l, err := c.readCount(code)
+		if err != nil {
+			return nil, err
+		}
+		return c.appendMap(dst, l, depth)
+	case IsExtFamily(code):
+		return c.appendExt(dst, code)
+	}
+	return nil, errors.Errorf(`msgpack: invalid code %s`, code)
+}
+
+// readCount reads the element count of an array or a map. It can not
+// exceed the number of remaining bytes, since each element takes at
+// least one byte
+func (c *canonicalizer) readCount(code Code) (int, error) {
+	var l int
+	switch code {
+	case Array16, Map16:
+		v, err := c.src.ReadUint16()
+		if err != nil {
+			return 0, err
+		}
+		l = int(v)
+	case Array32, Map32:
+		v, err := c.src.ReadUint32()
+		if err != nil {
+			return 0, err
+		}
+		l = int(v)
+	default:
+		l = int(code.Byte() & 0x0f)
+	}
+
+	if l > len(c.src.buf)-c.src.pos {
+		return 0, errors.Wrapf(io.ErrUnexpectedEOF, `msgpack: element count %d exceeds remaining input`, l)
+	}
+	return l, nil
+}
+
+func (c *canonicalizer) readPayload(code Code) ([]byte, error) {
+	var l int
+	switch code {
+	case Str8, Bin8:
+		v, err := c.src.ReadUint8()
+		if err != nil {
+			return nil, err
+		}
+		l = int(v)
+	case Str16, Bin16:
+		v, err := c.src.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		l = int(v)
+	case Str32, Bin32:
+		v, err := c.src.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		l = int(v)
+	default:
+		l = int(code.Byte() - FixStr0.Byte())
+	}
+	return c.src.next(l)
+}
+
+func (c *canonicalizer) appendMap(dst []byte, l int, depth int) ([]byte, error) {
+	// Keys and values are canonicalized into a separate buffer, so
+	// that they can be sorted before being appended to dst
+	var buf []byte
+	entries := make([]canonicalEntry, l)
+	for i := range entries {
+		var err error
+		entries[i].keyStart = len(buf)
+		if buf, err = c.appendValue(buf, depth+1); err != nil {
+			return nil, errors.Wrapf(err, `msgpack: failed to canonicalize map key at index %d`, i)
+		}
+		entries[i].keyEnd = len(buf)
+		if buf, err = c.appendValue(buf, depth+1); err != nil {
+			return nil, errors.Wrapf(err, `msgpack: failed to canonicalize map value at index %d`, i)
+		}
+		entries[i].valueEnd = len(buf)
+	}
+	sortCanonicalEntries(buf, entries)
+
+	dst = AppendMapHeader(dst, l)
+	for _, entry := range entries {
+		dst = append(dst, buf[entry.keyStart:entry.valueEnd]...)
+	}
+	return dst, nil
+}
+
+func (c *canonicalizer) appendExt(dst []byte, code Code) ([]byte, error) {
+	var l int
+	switch code {
+	case FixExt1:
+		l = 1
+	case FixExt2:
+		l = 2
+	case FixExt4:
+		l = 4
+	case FixExt8:
+		l = 8
+	case FixExt16:
+		l = 16
+	case Ext8:
+		v, err := c.src.ReadUint8()
+		if err != nil {
+			return nil, err
+		}
+		l = int(v)
+	case Ext16:
+		v, err := c.src.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		l = int(v)
+	case Ext32:
+		v, err := c.src.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		l = int(v)
+	}
+
+	typ, err := c.src.ReadByte()
+	if err != nil {
+		return nil, errors.Wrap(err, `msgpack: failed to read ext type`)
+	}
+	payload, err := c.src.next(l)
+	if err != nil {
+		return nil, errors.Wrap(err, `msgpack: failed to read ext payload`)
+	}
+
+	if typ != timestampExtByte {
+		return AppendExt(dst, int8(typ), payload), nil
+	}
+
+	var t time.Time
+	switch l {
+	case 4:
+		t = time.Unix(int64(binary.BigEndian.Uint32(payload)), 0)
+	case 8:
+		v := binary.BigEndian.Uint64(payload)
+		t = time.Unix(int64(v&(1<<34-1)), int64(v>>34))
+	case 12:
+		t = time.Unix(int64(binary.BigEndian.Uint64(payload[4:])), int64(binary.BigEndian.Uint32(payload)))
+	default:
+		return nil, errors.Errorf(`msgpack: invalid timestamp length %d`, l)
+	}
+	return AppendTime(dst, t), nil
+}
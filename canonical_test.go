@@ -0,0 +1,266 @@
+package msgpack_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+func marshalCanonical(t *testing.T, v interface{}) ([]byte, bool) {
+	t.Helper()
+	b, err := msgpack.Marshal(v, msgpack.WithCanonical(true))
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return nil, false
+	}
+	return b, true
+}
+
+func TestCanonicalEncoding(t *testing.T) {
+	t.Parallel()
+
+	t.Run("scalars", func(t *testing.T) {
+		t.Parallel()
+		testcases := []struct {
+			Name     string
+			Input    interface{}
+			Expected []byte
+		}{
+			{Name: "int64 positive fixnum", Input: int64(1), Expected: []byte{0x01}},
+			{Name: "int positive", Input: int(200), Expected: []byte{msgpack.Uint8.Byte(), 200}},
+			{Name: "int8 positive", Input: int8(100), Expected: []byte{100}},
+			{Name: "int64 negative fixnum", Input: int64(-32), Expected: []byte{0xe0}},
+			{Name: "int64 negative", Input: int64(-100), Expected: []byte{msgpack.Int8.Byte(), 0x9c}},
+			{Name: "int64 min", Input: int64(math.MinInt64), Expected: []byte{msgpack.Int64.Byte(), 0x80, 0, 0, 0, 0, 0, 0, 0}},
+			{Name: "uint64", Input: uint64(1000), Expected: []byte{msgpack.Uint16.Byte(), 0x03, 0xe8}},
+			{Name: "Number", Input: msgpack.Number{}, Expected: []byte{0x00}},
+			{Name: "float64 exact float32", Input: float64(1.5), Expected: []byte{msgpack.Float.Byte(), 0x3f, 0xc0, 0, 0}},
+			{Name: "float64", Input: float64(0.1), Expected: []byte{msgpack.Double.Byte(), 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+			{Name: "float64 NaN", Input: math.NaN(), Expected: []byte{msgpack.Float.Byte(), 0x7f, 0xc0, 0, 0}},
+			{Name: "float32 NaN", Input: float32(math.NaN()), Expected: []byte{msgpack.Float.Byte(), 0x7f, 0xc0, 0, 0}},
+			{Name: "float64 negative zero", Input: math.Copysign(0, -1), Expected: []byte{msgpack.Float.Byte(), 0, 0, 0, 0}},
+			{Name: "float32 negative zero", Input: float32(math.Copysign(0, -1)), Expected: []byte{msgpack.Float.Byte(), 0, 0, 0, 0}},
+		}
+
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				t.Parallel()
+				b, ok := marshalCanonical(t, tc.Input)
+				if !ok {
+					return
+				}
+				assert.Equal(t, tc.Expected, b, "output should match")
+			})
+		}
+	})
+	t.Run("maps", func(t *testing.T) {
+		t.Parallel()
+		v := map[string]interface{}{
+			"int":    1,
+			"string": "foo",
+			"nested": map[int]string{3: "c", 1: "a", 2: "b", -1: "z"},
+			"list":   []interface{}{map[string]int{"b": 2, "a": 1, "c": 3}},
+		}
+		for i := 0; i < 10; i++ {
+			m := make(map[string]interface{})
+			for k, v := range v {
+				m[k] = v
+			}
+			expected, ok := marshalCanonical(t, v)
+			if !ok {
+				return
+			}
+			b, ok := marshalCanonical(t, m)
+			if !ok {
+				return
+			}
+			if !assert.Equal(t, expected, b, "output should be deterministic") {
+				return
+			}
+		}
+
+		b, ok := marshalCanonical(t, map[int]string{3: "c", 1: "a", 2: "b", -1: "z"})
+		if !ok {
+			return
+		}
+		expected := []byte{0x84, 0x01, 0xa1, 'a', 0x02, 0xa1, 'b', 0x03, 0xa1, 'c', 0xff, 0xa1, 'z'}
+		assert.Equal(t, expected, b, "elements should be sorted by encoded keys")
+	})
+	t.Run("structs", func(t *testing.T) {
+		t.Parallel()
+		type Canonical struct {
+			Zeta  int
+			Alpha string
+			Mid   []float64
+		}
+		b1, ok := marshalCanonical(t, Canonical{Zeta: 1, Alpha: "a", Mid: []float64{0.5}})
+		if !ok {
+			return
+		}
+		b2, ok := marshalCanonical(t, map[string]interface{}{"Mid": []float32{0.5}, "Zeta": uint8(1), "Alpha": "a"})
+		if !ok {
+			return
+		}
+		assert.Equal(t, b1, b2, "equal values should have identical encodings")
+
+		var decoded Canonical
+		if assert.NoError(t, msgpack.Unmarshal(b1, &decoded), "Unmarshal should succeed") {
+			assert.Equal(t, Canonical{Zeta: 1, Alpha: "a", Mid: []float64{0.5}}, decoded, "decoded value should match")
+		}
+	})
+	t.Run("signed round trip", func(t *testing.T) {
+		t.Parallel()
+		type Signed struct {
+			Int      int
+			Int8     int8
+			Int16    int16
+			Int32    int32
+			Int64    int64
+			MaxInt64 int64
+			Negative int16
+			List     []int32
+			Uint32   uint32
+		}
+		input := Signed{
+			Int:      1 << 40,
+			Int8:     127,
+			Int16:    200,
+			Int32:    70000,
+			Int64:    1 << 33,
+			MaxInt64: math.MaxInt64,
+			Negative: -200,
+			List:     []int32{128, -1, 1 << 20},
+			Uint32:   1 << 31,
+		}
+		b, ok := marshalCanonical(t, input)
+		if !ok {
+			return
+		}
+
+		var decoded Signed
+		if assert.NoError(t, msgpack.Unmarshal(b, &decoded), "Unmarshal should succeed") {
+			assert.Equal(t, input, decoded, "decoded value should match")
+		}
+	})
+	t.Run("out of range", func(t *testing.T) {
+		t.Parallel()
+		var i16 int16
+		assert.Error(t, msgpack.Unmarshal(msgpack.AppendUint16(nil, 40000), &i16), "Uint16 40000 should not fit int16")
+		var i64 int64
+		assert.Error(t, msgpack.Unmarshal(msgpack.AppendUint64(nil, 1<<63), &i64), "Uint64 1<<63 should not fit int64")
+		var u32 uint32
+		assert.Error(t, msgpack.Unmarshal(msgpack.AppendInt32(nil, -1), &u32), "negative values should not fit uint32")
+		var u8 uint8
+		assert.Error(t, msgpack.Unmarshal([]byte{0xff}, &u8), "negative fixnums should not fit uint8")
+	})
+	t.Run("matches Canonicalize", func(t *testing.T) {
+		t.Parallel()
+		v := map[interface{}]interface{}{
+			"time":    time.Unix(1000, 0),
+			int64(-1): []interface{}{int64(300), float64(2), "x"},
+			"raw":     msgpack.RawMessage{msgpack.Int64.Byte(), 0, 0, 0, 0, 0, 0, 0, 5},
+		}
+		expected, ok := marshalCanonical(t, v)
+		if !ok {
+			return
+		}
+		b, err := msgpack.Marshal(v)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		canonical, err := msgpack.Canonicalize(b)
+		if !assert.NoError(t, err, "Canonicalize should succeed") {
+			return
+		}
+		assert.Equal(t, expected, canonical, "Canonicalize should match canonical encoding")
+	})
+}
+
+func TestCanonicalize(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		Name     string
+		Input    []byte
+		Expected []byte
+	}{
+		{
+			Name:     "str32",
+			Input:    []byte{msgpack.Str32.Byte(), 0, 0, 0, 1, 'a'},
+			Expected: []byte{0xa1, 'a'},
+		},
+		{
+			Name:     "bin32",
+			Input:    []byte{msgpack.Bin32.Byte(), 0, 0, 0, 1, 'a'},
+			Expected: []byte{msgpack.Bin8.Byte(), 1, 'a'},
+		},
+		{
+			Name:     "array32",
+			Input:    []byte{msgpack.Array32.Byte(), 0, 0, 0, 2, msgpack.Int16.Byte(), 0, 1, msgpack.Uint32.Byte(), 0, 0, 1, 0},
+			Expected: []byte{0x92, 0x01, msgpack.Uint16.Byte(), 1, 0},
+		},
+		{
+			Name:     "map16 with unsorted keys",
+			Input:    []byte{msgpack.Map16.Byte(), 0, 2, 0xa1, 'b', 0x02, 0xa1, 'a', 0x01},
+			Expected: []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02},
+		},
+		{
+			Name:     "ext8",
+			Input:    []byte{msgpack.Ext8.Byte(), 2, 0x05, 'a', 'b'},
+			Expected: []byte{msgpack.FixExt2.Byte(), 0x05, 'a', 'b'},
+		},
+		{
+			Name:     "timestamp 96",
+			Input:    []byte{msgpack.Ext8.Byte(), 12, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x03, 0xe8},
+			Expected: []byte{msgpack.FixExt4.Byte(), 0xff, 0, 0, 0x03, 0xe8},
+		},
+		{
+			Name:     "negative zero",
+			Input:    []byte{msgpack.Double.Byte(), 0x80, 0, 0, 0, 0, 0, 0, 0, msgpack.Float.Byte(), 0x80, 0, 0, 0},
+			Expected: []byte{msgpack.Float.Byte(), 0, 0, 0, 0, msgpack.Float.Byte(), 0, 0, 0, 0},
+		},
+		{
+			Name:     "multiple values",
+			Input:    []byte{msgpack.Int64.Byte(), 0, 0, 0, 0, 0, 0, 0, 1, msgpack.Nil.Byte()},
+			Expected: []byte{0x01, msgpack.Nil.Byte()},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			b, err := msgpack.Canonicalize(tc.Input)
+			if !assert.NoError(t, err, "Canonicalize should succeed") {
+				return
+			}
+			if !assert.Equal(t, tc.Expected, b, "output should match") {
+				return
+			}
+
+			// Canonicalizing again should not change anything
+			b, err = msgpack.Canonicalize(b)
+			if !assert.NoError(t, err, "Canonicalize should succeed") {
+				return
+			}
+			assert.Equal(t, tc.Expected, b, "canonical form should be stable")
+		})
+	}
+
+	t.Run("invalid input", func(t *testing.T) {
+		t.Parallel()
+		for _, input := range [][]byte{
+			{msgpack.Str8.Byte(), 5, 'a'},
+			{msgpack.Array32.Byte(), 0xff, 0xff, 0xff, 0xff},
+			{msgpack.FixExt4.Byte(), 0xff, 0, 0},
+			{msgpack.FixExt1.Byte(), 0xff, 0},
+			{0xc1},
+		} {
+			_, err := msgpack.Canonicalize(input)
+			assert.Error(t, err, "Canonicalize(%x) should fail", input)
+		}
+	})
+}
@@ -2,8 +2,11 @@ package msgpack
 
 import (
 	"bufio"
+	"encoding"
+	"fmt"
 	"io"
 	"reflect"
+	"sync"
 	"time"
 
 	bufferpool "github.com/lestrrat-go/bufferpool"
@@ -11,8 +14,8 @@ import (
 )
 
 // NewDecoder creates a Decoder instance
-func NewDecoder(r io.Reader) Decoder {
-	d := &decoder{nl: &decoderNL{}}
+func NewDecoder(r io.Reader, options ...Option) Decoder {
+	d := &decoder{nl: newDecoderNL(options)}
 	d.nl.SetSource(r)
 	return d
 }
@@ -22,12 +25,62 @@ func NewDecoder(r io.Reader) Decoder {
 // method access. If you have complete control over the usage of
 // this object, then the object returned by this constructor will
 // shorten a whopping 30~50ns per method call. Use at your own peril
-func NewDecoderNoLock(r io.Reader) Decoder {
-	d := &decoderNL{}
+func NewDecoderNoLock(r io.Reader, options ...Option) Decoder {
+	d := newDecoderNL(options)
 	d.SetSource(r)
 	return d
 }
 
+func newDecoderNL(options []Option) *decoderNL {
+	d := &decoderNL{}
+	for _, option := range options {
+		switch option.Name() {
+		case optkeyMapKeyMode:
+			d.mapKeyMode = option.Value().(MapKeyMode)
+		case optkeyMapType:
+			d.mapType = option.Value().(MapType)
+		case optkeyBinAsString:
+			d.binAsString = option.Value().(bool)
+		case optkeyDisallowUnknownFields:
+			d.disallowUnknownFields = option.Value().(bool)
+		case optkeyCaseInsensitiveFields:
+			d.caseInsensitiveFields = option.Value().(bool)
+		case optkeyUseNumber:
+			d.useNumber = option.Value().(bool)
+		case optkeyNormalizeIntegers:
+			d.normalizeIntegers = option.Value().(bool)
+		case optkeyMaxLength:
+			d.maxLength = option.Value().(int)
+		case optkeyMaxElements:
+			d.maxElements = option.Value().(int)
+		case optkeyMaxDepth:
+			d.maxDepth = option.Value().(int)
+		case optkeyMaxTotalBytes:
+			d.maxTotalBytes = option.Value().(int64)
+		case optkeyZeroCopy:
+			d.zeroCopy = option.Value().(bool)
+		}
+	}
+	return d
+}
+
+// NewDecoderBytes creates a Decoder that reads directly from the byte
+// slice b, without the intermediate buffering required for io.Readers.
+// Use the WithZeroCopy option to avoid copying data out of b
+func NewDecoderBytes(b []byte, options ...Option) Decoder {
+	d := &decoder{nl: newDecoderNL(options)}
+	d.nl.setSourceBytes(b)
+	return d
+}
+
+// NewDecoderBytesNoLock is the non-locking version of NewDecoderBytes.
+// See NewDecoderNoLock for the caveats
+func NewDecoderBytesNoLock(b []byte, options ...Option) Decoder {
+	d := newDecoderNL(options)
+	d.setSourceBytes(b)
+	return d
+}
+
 func (d *decoder) SetSource(r io.Reader) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -35,8 +88,52 @@ func (d *decoder) SetSource(r io.Reader) {
 }
 
 func (dnl *decoderNL) SetSource(r io.Reader) {
-	dnl.raw = bufio.NewReader(r)
+	if dnl.maxTotalBytes > 0 {
+		r = &limitReader{src: r, limit: dnl.maxTotalBytes, remaining: dnl.maxTotalBytes}
+	}
+	dnl.depth = 0
+	dnl.counter = &countingReader{src: r}
+	dnl.raw = bufio.NewReader(dnl.counter)
 	dnl.src = NewReader(dnl.raw)
+	dnl.slice = nil
+}
+
+func (dnl *decoderNL) setSourceBytes(b []byte) {
+	sr := newSliceReader(b)
+	if dnl.maxTotalBytes > 0 && int64(len(b)) > dnl.maxTotalBytes {
+		sr.buf = b[:dnl.maxTotalBytes]
+		sr.eof = &LimitError{Kind: LimitTotalBytes, Limit: dnl.maxTotalBytes}
+	}
+	dnl.depth = 0
+	dnl.raw = sr
+	dnl.src = sr
+	dnl.slice = sr
+	dnl.counter = nil
+}
+
+// InputOffset returns the number of bytes of the source that have
+// been consumed so far
+func (dnl *decoderNL) InputOffset() int64 {
+	if dnl.slice != nil {
+		return int64(dnl.slice.pos)
+	}
+	if dnl.counter == nil {
+		return 0
+	}
+	// Bytes that are sitting in the buffer have not been consumed yet
+	return dnl.counter.n - int64(dnl.raw.(*bufio.Reader).Buffered())
+}
+
+// countingReader counts the number of bytes read from src
+type countingReader struct {
+	src io.Reader
+	n   int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	r.n += int64(n)
+	return n, err
 }
 
 func (dnl *decoderNL) Reader() Reader {
@@ -138,18 +235,41 @@ func (dnl *decoderNL) DecodeBytes(v *[]byte) error {
 	if l < 0 {
 		return errors.Errorf(`msgpack: invalid byte slice length %d`, l)
 	}
+	if err := dnl.checkLength(l); err != nil {
+		return err
+	}
+
+	b, err := dnl.readPayload(int(l))
+	if err != nil {
+		return errors.Wrap(err, `msgpack: failed to read byte slice`)
+	}
+	*v = b
+	return nil
+}
+
+// readPayload reads the next l bytes. The result aliases the source
+// if the decoder is reading from a byte slice in zero-copy mode
+func (dnl *decoderNL) readPayload(l int) ([]byte, error) {
+	if dnl.slice != nil {
+		b, err := dnl.slice.next(l)
+		if err != nil {
+			return nil, err
+		}
+		if !dnl.zeroCopy {
+			b = append([]byte(nil), b...)
+		}
+		return b, nil
+	}
 
 	b := make([]byte, l)
 	for x := b; len(x) > 0; {
 		n, err := dnl.raw.Read(x)
 		if err != nil {
-			return errors.Wrap(err, `msgpack: failed to read byte slice`)
+			return nil, err
 		}
 		x = x[n:]
 	}
-
-	*v = b
-	return nil
+	return b, nil
 }
 
 func (dnl *decoderNL) DecodeString(s *string) error {
@@ -188,6 +308,22 @@ func (dnl *decoderNL) DecodeString(s *string) error {
 	if l < 0 {
 		return errors.Errorf(`msgpack: invalid string length %d`, l)
 	}
+	if err := dnl.checkLength(l); err != nil {
+		return err
+	}
+
+	if dnl.slice != nil {
+		b, err := dnl.slice.next(int(l))
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read string`)
+		}
+		if dnl.zeroCopy {
+			*s = unsafeString(b)
+		} else {
+			*s = string(b)
+		}
+		return nil
+	}
 
 	// Read the contents of the string.
 	// Now, here's the tricky part: conversion from byte slice to string is
@@ -220,7 +356,7 @@ func (dnl *decoderNL) DecodeArrayLength(l *int) error {
 
 	if code >= FixArray0 && code <= FixArray15 {
 		*l = int(code.Byte() - FixArray0.Byte())
-		return nil
+		return dnl.checkElements(*l)
 	}
 
 	switch code {
@@ -240,7 +376,7 @@ func (dnl *decoderNL) DecodeArrayLength(l *int) error {
 		return errors.Errorf(`msgpack: unsupported array type %s`, code)
 	}
 
-	return nil
+	return dnl.checkElements(*l)
 }
 
 func (dnl *decoderNL) DecodeArray(v interface{}) error {
@@ -290,7 +426,7 @@ func (dnl *decoderNL) DecodeMapLength(l *int) error {
 
 	if code >= FixMap0 && code <= FixMap15 {
 		*l = int(code.Byte() - FixMap0.Byte())
-		return nil
+		return dnl.checkElements(*l)
 	}
 
 	switch code {
@@ -310,7 +446,7 @@ func (dnl *decoderNL) DecodeMapLength(l *int) error {
 		return errors.Errorf(`msgpack: unsupported map type %s`, code)
 	}
 
-	return nil
+	return dnl.checkElements(*l)
 }
 
 func (dnl *decoderNL) DecodeMap(v *map[string]interface{}) error {
@@ -341,7 +477,425 @@ func (dnl *decoderNL) DecodeMap(v *map[string]interface{}) error {
 	return nil
 }
 
+// decodeGenericMap decodes a map when the destination does not tell
+// us what type to use. Maps with all string keys are decoded into
+// map[string]interface{}. Otherwise the result depends on the
+// MapKeyMode of the decoder
+func (dnl *decoderNL) decodeGenericMap() (interface{}, error) {
+	var size int
+	if err := dnl.DecodeMapLength(&size); err != nil {
+		return nil, errors.Wrap(err, `msgpack: failed to decode map length`)
+	}
+
+	if size == -1 {
+		return nil, nil
+	}
+
+	var m map[string]interface{}
+	var im map[interface{}]interface{}
+	if dnl.mapType == MapTypeInterface {
+		im = make(map[interface{}]interface{})
+	} else {
+		m = make(map[string]interface{})
+	}
+	for i := 0; i < size; i++ {
+		var key interface{}
+		if err := dnl.Decode(&key); err != nil {
+			return nil, errors.Wrap(err, `msgpack: failed to decode map key`)
+		}
+
+		var value interface{}
+		if err := dnl.Decode(&value); err != nil {
+			return nil, errors.Wrapf(err, `msgpack: failed to decode map element for key %v`, key)
+		}
+
+		if im != nil {
+			hkey, err := hashableKey(key)
+			if err != nil {
+				return nil, err
+			}
+			im[hkey] = value
+			continue
+		}
+
+		if s, ok := key.(string); ok {
+			m[s] = value
+			continue
+		}
+
+		switch dnl.mapKeyMode {
+		case MapKeyStringify:
+			if b, ok := key.([]byte); ok {
+				key = string(b)
+			}
+			m[fmt.Sprint(key)] = value
+		case MapKeyStrict:
+			return nil, errors.Errorf(`msgpack: map key must be a string (got %T)`, key)
+		default:
+			// Switch over to a map that can hold any key, and carry
+			// over what we have decoded so far
+			im = make(map[interface{}]interface{}, size)
+			for k, v := range m {
+				im[k] = v
+			}
+			hkey, err := hashableKey(key)
+			if err != nil {
+				return nil, err
+			}
+			im[hkey] = value
+		}
+	}
+
+	if im != nil {
+		return im, nil
+	}
+	return m, nil
+}
+
+// hashableKey makes sure that key can be used as a map key. []byte keys
+// are converted to strings, other unhashable keys result in an error
+func hashableKey(key interface{}) (interface{}, error) {
+	if b, ok := key.([]byte); ok {
+		return string(b), nil
+	}
+	// Slices, maps and OrderedMaps can not be used as keys, as
+	// indexing a map with them panics
+	if t := reflect.TypeOf(key); t != nil && !t.Comparable() {
+		return nil, errors.Errorf(`msgpack: unhashable map key type %T`, key)
+	}
+	return key, nil
+}
+
+// decodeMapInterface decodes a map into a map[interface{}]interface{}
+func (dnl *decoderNL) decodeMapInterface(v *map[interface{}]interface{}) error {
+	var size int
+	if err := dnl.DecodeMapLength(&size); err != nil {
+		return errors.Wrap(err, `msgpack: failed to decode map length`)
+	}
+
+	if size == -1 {
+		*v = nil
+		return nil
+	}
+
+	m := make(map[interface{}]interface{}, size)
+	for i := 0; i < size; i++ {
+		var key interface{}
+		if err := dnl.Decode(&key); err != nil {
+			return errors.Wrap(err, `msgpack: failed to decode map key`)
+		}
+		hkey, err := hashableKey(key)
+		if err != nil {
+			return err
+		}
+
+		var value interface{}
+		if err := dnl.Decode(&value); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to decode map element for key %v`, key)
+		}
+		m[hkey] = value
+	}
+	*v = m
+	return nil
+}
+
+var binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// decoderInterfaces records which of the interfaces that take over
+// decoding are implemented by a type
+type decoderInterfaces uint8
+
+const (
+	implementsDecodeMsgpacker decoderInterfaces = 1 << iota
+	// encoding.BinaryUnmarshaler or encoding.TextUnmarshaler
+	implementsUnmarshaler
+)
+
+var muDecoderInterfaces sync.RWMutex
+var decoderInterfacesRegistry = make(map[reflect.Type]decoderInterfaces)
+
+// getDecoderInterfaces returns the interfaces implemented by t. The
+// result is cached, as Implements is too expensive to call for every
+// decoded value
+func getDecoderInterfaces(t reflect.Type) decoderInterfaces {
+	muDecoderInterfaces.RLock()
+	ifaces, ok := decoderInterfacesRegistry[t]
+	muDecoderInterfaces.RUnlock()
+	if ok {
+		return ifaces
+	}
+
+	if t.Implements(decodeMsgpackerType) {
+		ifaces |= implementsDecodeMsgpacker
+	}
+	if !isTimeType(t) && (t.Implements(binaryUnmarshalerType) || t.Implements(textUnmarshalerType)) {
+		ifaces |= implementsUnmarshaler
+	}
+
+	muDecoderInterfaces.Lock()
+	decoderInterfacesRegistry[t] = ifaces
+	muDecoderInterfaces.Unlock()
+	return ifaces
+}
+
+func isDecodeMsgpacker(t reflect.Type) bool {
+	return getDecoderInterfaces(t)&implementsDecodeMsgpacker != 0
+}
+
+// isUnmarshaler returns true if t implements encoding.BinaryUnmarshaler
+// or encoding.TextUnmarshaler
+func isUnmarshaler(t reflect.Type) bool {
+	return getDecoderInterfaces(t)&implementsUnmarshaler != 0
+}
+
+// decodeUnmarshaler decodes into values that implement
+// encoding.BinaryUnmarshaler or encoding.TextUnmarshaler. Both bin
+// and str are accepted. If both interfaces are implemented,
+// encoding.BinaryUnmarshaler is used for bin, and
+// encoding.TextUnmarshaler is used for str
+func (dnl *decoderNL) decodeUnmarshaler(rv reflect.Value) error {
+	code, err := dnl.PeekCode()
+	if err != nil {
+		return errors.Wrap(err, `msgpack: failed to peek code`)
+	}
+
+	var b []byte
+	switch {
+	case code == Nil:
+		if err := dnl.DecodeNil(nil); err != nil {
+			return err
+		}
+		rv.Elem().Set(reflect.Zero(rv.Type().Elem()))
+		return nil
+	case IsStrFamily(code):
+		var s string
+		if err := dnl.DecodeString(&s); err != nil {
+			return err
+		}
+		b = []byte(s)
+	default:
+		if err := dnl.DecodeBytes(&b); err != nil {
+			return err
+		}
+	}
+
+	bu, isBinary := rv.Interface().(encoding.BinaryUnmarshaler)
+	tu, isText := rv.Interface().(encoding.TextUnmarshaler)
+	if isBinary && (!isText || !IsStrFamily(code)) {
+		if err := bu.UnmarshalBinary(b); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to call UnmarshalBinary on %s`, rv.Type())
+		}
+		return nil
+	}
+
+	if err := tu.UnmarshalText(b); err != nil {
+		return errors.Wrapf(err, `msgpack: failed to call UnmarshalText on %s`, rv.Type())
+	}
+	return nil
+}
+
+// decodeArrayReflect decodes into a fixed size Go array. [N]byte is
+// decoded from bin, and all other arrays are decoded from msgpack arrays.
+// The number of elements must match the length of the Go array exactly
+func (dnl *decoderNL) decodeArrayReflect(rv reflect.Value) error {
+	if dnl.isNil() {
+		if err := dnl.DecodeNil(nil); err != nil {
+			return err
+		}
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	if rv.Type().Elem().Kind() == reflect.Uint8 {
+		if code, err := dnl.PeekCode(); err == nil && IsBinFamily(code) {
+			var b []byte
+			if err := dnl.DecodeBytes(&b); err != nil {
+				return errors.Wrapf(err, `msgpack: failed to decode %s`, rv.Type())
+			}
+			if len(b) != rv.Len() {
+				return errors.Errorf(`msgpack: expected %d bytes for %s, got %d`, rv.Len(), rv.Type(), len(b))
+			}
+			reflect.Copy(rv, reflect.ValueOf(b))
+			return nil
+		}
+	}
+
+	var size int
+	if err := dnl.DecodeArrayLength(&size); err != nil {
+		return errors.Wrap(err, `msgpack: failed to decode array length`)
+	}
+
+	if size != rv.Len() {
+		return errors.Errorf(`msgpack: expected %d elements for %s, got %d`, rv.Len(), rv.Type(), size)
+	}
+
+	for i := 0; i < size; i++ {
+		if err := dnl.decodeElement(rv.Index(i)); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to decode array element %d`, i)
+		}
+	}
+	return nil
+}
+
+// decodeMapReflect decodes a map into an arbitrary map type
+// using reflection. If the map is nil, a new map is allocated.
+// Otherwise the decoded elements are added to the existing map
+func (dnl *decoderNL) decodeMapReflect(rv reflect.Value) error {
+	var size int
+	if err := dnl.DecodeMapLength(&size); err != nil {
+		return errors.Wrap(err, `msgpack: failed to decode map length`)
+	}
+
+	if size == -1 {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	rt := rv.Type()
+	m := rv
+	if m.IsNil() {
+		m = reflect.MakeMap(rt)
+	}
+	for i := 0; i < size; i++ {
+		key := reflect.New(rt.Key()).Elem()
+		if err := dnl.decodeMapKey(key); err != nil {
+			return errors.Wrap(err, `msgpack: failed to decode map key`)
+		}
+
+		value := reflect.New(rt.Elem()).Elem()
+		if err := dnl.decodeElement(value); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to decode map element for key %v`, key.Interface())
+		}
+		m.SetMapIndex(key, value)
+	}
+	rv.Set(m)
+	return nil
+}
+
+// decodeElement decodes a container element into rv. Pointers are
+// allocated as necessary, and are left as nil for nil values
+func (dnl *decoderNL) decodeElement(rv reflect.Value) error {
+	if rv.Kind() != reflect.Ptr {
+		return dnl.Decode(rv.Addr().Interface())
+	}
+
+	if dnl.isNil() {
+		if err := dnl.DecodeNil(nil); err != nil {
+			return err
+		}
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	if rv.IsNil() {
+		rv.Set(reflect.New(rv.Type().Elem()))
+	}
+	return dnl.Decode(rv.Interface())
+}
+
+// decodeMapKey decodes a map key into rv. Keys are decoded leniently,
+// so that for example an integer key encoded as uint8 by another
+// implementation can still be decoded into an int
+func (dnl *decoderNL) decodeMapKey(rv reflect.Value) error {
+	if rv.Kind() == reflect.String {
+		if code, err := dnl.PeekCode(); err == nil && IsStrFamily(code) {
+			var s string
+			if err := dnl.DecodeString(&s); err != nil {
+				return err
+			}
+			rv.SetString(s)
+			return nil
+		}
+	}
+
+	if rv.Kind() == reflect.Array {
+		return dnl.decodeArrayReflect(rv)
+	}
+
+	var key interface{}
+	if err := dnl.Decode(&key); err != nil {
+		return err
+	}
+
+	if key == nil {
+		return errors.New(`msgpack: map key must not be nil`)
+	}
+
+	if rv.Kind() == reflect.Interface {
+		hkey, err := hashableKey(key)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(hkey))
+		return nil
+	}
+	return assignIfCompatible(rv, reflect.ValueOf(key))
+}
+
+// DecodeTime decodes a time.Time value. The timestamp extension
+// (type -1) in all of its timestamp 32, timestamp 64, and timestamp 96
+// formats is accepted, as well as the legacy format where time.Time
+// was encoded as an array of seconds and nanoseconds
 func (dnl *decoderNL) DecodeTime(v *time.Time) error {
+	code, err := dnl.PeekCode()
+	if err != nil {
+		return errors.Wrap(err, `msgpack: failed to peek code for time.Time`)
+	}
+
+	if IsArrayFamily(code) {
+		return dnl.decodeLegacyTime(v)
+	}
+
+	var size int
+	if err := dnl.DecodeExtLength(&size); err != nil {
+		return errors.Wrap(err, `msgpack: failed to decode extension length for time.Time`)
+	}
+
+	typ, err := dnl.src.ReadByte()
+	if err != nil {
+		return errors.Wrap(err, `msgpack: failed to read extension type for time.Time`)
+	}
+	if typ != timestampExtByte {
+		return errors.Errorf(`msgpack: expected timestamp extension type -1, got %d`, int8(typ))
+	}
+
+	return dnl.decodeTimestamp(size, v)
+}
+
+// decodeTimestamp decodes the payload of the timestamp extension.
+// The header and the extension type must have already been consumed
+func (dnl *decoderNL) decodeTimestamp(size int, v *time.Time) error {
+	switch size {
+	case 4:
+		sec, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read seconds for timestamp 32`)
+		}
+		*v = time.Unix(int64(sec), 0)
+	case 8:
+		x, err := dnl.src.ReadUint64()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for timestamp 64`)
+		}
+		*v = time.Unix(int64(x&0x00000003ffffffff), int64(x>>34))
+	case 12:
+		nsec, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read nanoseconds for timestamp 96`)
+		}
+		sec, err := dnl.src.ReadUint64()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read seconds for timestamp 96`)
+		}
+		*v = time.Unix(int64(sec), int64(nsec))
+	default:
+		return errors.Errorf(`msgpack: invalid payload length %d for timestamp extension`, size)
+	}
+	return nil
+}
+
+func (dnl *decoderNL) decodeLegacyTime(v *time.Time) error {
 	var size int
 	if err := dnl.DecodeArrayLength(&size); err != nil {
 		return errors.Wrap(err, `msgpack: failed to decode array length for time.Time`)
@@ -372,17 +926,22 @@ func (dnl *decoderNL) DecodeStruct(v interface{}) error {
 		return dnl.DecodeTime(v)
 	}
 
-	var size int
-	if err := dnl.DecodeMapLength(&size); err != nil {
-		return errors.Wrap(err, `msgpack: failed to decode map length`)
-	}
-
 	var rv = reflect.ValueOf(v)
 	// You better be a pointer to a struct, damnit
 	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
 		return errors.New(`msgpack: expected pointer to struct`)
 	}
 
+	// Structs may have been encoded as arrays of field values
+	if code, err := dnl.PeekCode(); err == nil && IsArrayFamily(code) {
+		return dnl.decodeStructArray(rv.Elem())
+	}
+
+	var size int
+	if err := dnl.DecodeMapLength(&size); err != nil {
+		return errors.Wrap(err, `msgpack: failed to decode map length`)
+	}
+
 	if size == -1 {
 		if rv.CanSet() {
 			rv.Set(reflect.Value{})
@@ -390,31 +949,27 @@ func (dnl *decoderNL) DecodeStruct(v interface{}) error {
 		return nil
 	}
 
-	var rt = rv.Elem().Type()
-	// Find the fields
-	name2field := map[string]reflect.Value{}
-	for i := 0; i < rt.NumField(); i++ {
-		field := rt.Field(i)
-		if field.PkgPath != "" {
-			continue
-		}
-
-		name, _ := parseMsgpackTag(field)
-		if name == "-" {
-			continue
-		}
-
-		name2field[name] = rv.Elem().Field(i)
-	}
+	rv = rv.Elem()
+	info := getStructInfo(rv.Type())
 
 	var key string
 	for i := 0; i < size; i++ {
-		if err := dnl.Decode(&key); err != nil {
+		if err := dnl.DecodeString(&key); err != nil {
 			return errors.Wrapf(err, `msgpack: failed to decode struct key at index %d`, i)
 		}
 
-		f, ok := name2field[key]
+		field, ok := info.byName[key]
+		if !ok && dnl.caseInsensitiveFields {
+			field, ok = info.lookupFolded(key)
+		}
 		if !ok {
+			if dnl.disallowUnknownFields {
+				return &UnknownFieldError{Key: key, Type: rv.Type()}
+			}
+			// Unknown field, throw the value away
+			if err := dnl.Skip(); err != nil {
+				return errors.Wrapf(err, `msgpack: failed to decode value for unknown key %s`, key)
+			}
 			continue
 		}
 		if dnl.isNil() {
@@ -424,39 +979,53 @@ func (dnl *decoderNL) DecodeStruct(v interface{}) error {
 			continue
 		}
 
-		if f.Kind() == reflect.Slice {
-			r := reflect.New(f.Type()).Elem()
-			if err := dnl.Decode(r.Addr().Interface()); err != nil {
-				return errors.Wrapf(err, `msgpack: failed to decode slice value for key %s`, key)
-			}
-			f.Set(r)
-		} else if f.Kind() == reflect.Struct {
-			if err := dnl.Decode(f.Addr().Interface()); err != nil {
-				return errors.Wrapf(err, `msgpack: failed to decode struct value for key %s (struct)`, key)
-			}
-		} else if f.Kind() == reflect.Ptr && f.Type().Elem().Kind() == reflect.Struct {
-			r := reflect.New(f.Type().Elem())
-			if err := dnl.Decode(r.Interface()); err != nil {
-				return errors.Wrapf(err, `msgpack: failed to decode struct value for key %s (pointer to struct)`, key)
-			}
-			f.Set(r)
-		} else {
-			var fv reflect.Value
-			if f.Kind() == reflect.Ptr {
-				fv = reflect.New(f.Type().Elem())
-			} else {
-				fv = reflect.New(f.Type())
-			}
-			if err := dnl.Decode(fv.Interface()); err != nil {
-				return errors.Wrapf(err, `msgpack: failed to decode struct value for key %s (not struct/pointer to struct)`, key)
+		fv, err := field.fieldByIndexAlloc(rv)
+		if err != nil {
+			return errors.Wrapf(err, `msgpack: failed to decode struct value for key %s`, key)
+		}
+		if err := field.decode(dnl, fv); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to decode struct value for key %s`, key)
+		}
+	}
+
+	return nil
+}
+
+// decodeStructArray decodes an array of field values into a struct.
+// Values are assigned to fields in the order that they are declared.
+// Extra values are discarded, and fields without a corresponding
+// value are left untouched
+func (dnl *decoderNL) decodeStructArray(rv reflect.Value) error {
+	var size int
+	if err := dnl.DecodeArrayLength(&size); err != nil {
+		return errors.Wrap(err, `msgpack: failed to decode array length`)
+	}
+
+	info := getStructInfo(rv.Type())
+	for i := 0; i < size; i++ {
+		if i >= len(info.fields) {
+			if err := dnl.Skip(); err != nil {
+				return errors.Wrapf(err, `msgpack: failed to decode extra value at index %d`, i)
 			}
+			continue
+		}
 
-			if err := assignIfCompatible(f, fv.Elem()); err != nil {
-				return errors.Wrapf(err, `msgpack: failed to assign struct value for key %s`, key)
+		field := info.fields[i]
+		if dnl.isNil() {
+			if err := dnl.DecodeNil(nil); err != nil {
+				return errors.Wrapf(err, `msgpack: failed to decode nil field %s`, field.name)
 			}
+			continue
 		}
-	}
 
+		fv, err := field.fieldByIndexAlloc(rv)
+		if err != nil {
+			return errors.Wrapf(err, `msgpack: failed to decode struct value for field %s`, field.name)
+		}
+		if err := field.decode(dnl, fv); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to decode struct value for field %s`, field.name)
+		}
+	}
 	return nil
 }
 
@@ -555,6 +1124,27 @@ func assignIfCompatible(dst, src reflect.Value) (err error) {
 var emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
 
 func (dnl *decoderNL) Decode(v interface{}) error {
+	if dnl.maxDepth <= 0 {
+		return dnl.decode(v)
+	}
+
+	if dnl.depth >= dnl.maxDepth {
+		return &LimitError{Kind: LimitDepth, Limit: int64(dnl.maxDepth), Value: int64(dnl.depth + 1)}
+	}
+	dnl.depth++
+	err := dnl.decode(v)
+	dnl.depth--
+
+	// Limit errors are returned as is. Otherwise every nesting level
+	// would wrap them again, and hostile input could produce huge
+	// error messages
+	if le, ok := errors.Cause(err).(*LimitError); ok {
+		return le
+	}
+	return err
+}
+
+func (dnl *decoderNL) decode(v interface{}) error {
 	rv := reflect.ValueOf(v)
 
 	// The result of decoding must be assigned to v, and v
@@ -610,10 +1200,27 @@ func (dnl *decoderNL) Decode(v interface{}) error {
 		return dnl.DecodeString(v)
 	case *map[string]interface{}:
 		return dnl.DecodeMap(v)
+	case *map[interface{}]interface{}:
+		return dnl.decodeMapInterface(v)
+	case *RawMessage:
+		return dnl.decodeRaw(v)
 	case DecodeMsgpacker:
+		// Registered extensions need their header consumed before
+		// the object gets to decode its payload
+		if isExtDecodeType(rv.Type().Elem()) {
+			return dnl.DecodeExt(v)
+		}
 		// If we know this object does its own decoding, we bypass everything
 		// and just let it handle itself
 		return v.DecodeMsgpack(dnl)
+	case *time.Time:
+		// time.Time implements encoding.BinaryUnmarshaler, but it
+		// needs to be decoded from the timestamp extension
+		return dnl.DecodeTime(v)
+	}
+
+	if isUnmarshaler(rv.Type()) {
+		return dnl.decodeUnmarshaler(rv)
 	}
 
 	// Next up: try using reflect to find out the general family of
@@ -621,6 +1228,10 @@ func (dnl *decoderNL) Decode(v interface{}) error {
 	switch rv.Elem().Kind() {
 	case reflect.Struct:
 		return dnl.DecodeStruct(v)
+	case reflect.Map:
+		return dnl.decodeMapReflect(rv.Elem())
+	case reflect.Array:
+		return dnl.decodeArrayReflect(rv.Elem())
 	case reflect.Slice:
 		list := reflect.New(rv.Elem().Type())
 		if err := dnl.DecodeArray(list.Interface()); err != nil {
@@ -672,6 +1283,19 @@ FromCode:
 	return errors.Errorf(`msgpack: cannot assign %s to %s`, dv.Type(), dst.Type())
 }
 
+// isInterfaceHint returns true if v is a (possibly indirect) pointer
+// to an interface
+func isInterfaceHint(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return false
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Interface
+}
+
 // Note: v is only used as a hint. do not assign to it inside this method
 func (dnl *decoderNL) decodeInterface(v interface{}) (interface{}, error) {
 	code, err := dnl.PeekCode()
@@ -679,6 +1303,21 @@ func (dnl *decoderNL) decodeInterface(v interface{}) (interface{}, error) {
 		return nil, errors.Wrap(err, `msgpack: failed to peek code`)
 	}
 
+	// Numbers are only decoded as Number when they are stored in an
+	// interface{}. Concrete destinations, including named types such
+	// as `type Foo int`, get their usual values
+	useNumber := dnl.useNumber && isInterfaceHint(v)
+	if (useNumber || dnl.normalizeIntegers) && IsNumberFamily(code) {
+		var n Number
+		if err := n.DecodeMsgpack(dnl); err != nil {
+			return nil, errors.Wrap(err, `msgpack: failed to decode number`)
+		}
+		if useNumber {
+			return n, nil
+		}
+		return n.normalize(), nil
+	}
+
 	switch {
 	case IsExtFamily(code):
 		var size int
@@ -686,6 +1325,19 @@ func (dnl *decoderNL) decodeInterface(v interface{}) (interface{}, error) {
 			return nil, errors.Wrap(err, `msgpack: failed to read extension sizes`)
 		}
 
+		// The timestamp extension is built-in, and does not live in
+		// the extension registry
+		if b, err := dnl.raw.Peek(1); err == nil && b[0] == timestampExtByte {
+			if _, err := dnl.raw.ReadByte(); err != nil {
+				return nil, errors.Wrap(err, `msgpack: failed to read extension type`)
+			}
+			var t time.Time
+			if err := dnl.decodeTimestamp(size, &t); err != nil {
+				return nil, errors.Wrap(err, `msgpack: failed to decode timestamp`)
+			}
+			return t, nil
+		}
+
 		var typ reflect.Type
 		if err := dnl.DecodeExtType(&typ); err != nil {
 			return nil, errors.Wrap(err, `msgpack: faied to read extension type`)
@@ -697,6 +1349,9 @@ func (dnl *decoderNL) decodeInterface(v interface{}) (interface{}, error) {
 		}
 		return rv, nil
 	case IsFixNumFamily(code):
+		if _, err := dnl.raw.ReadByte(); err != nil {
+			return nil, errors.Wrap(err, `msgpack: failed to read byte`)
+		}
 		return int8(code), nil
 	case code == Nil:
 		// Optimization: doesn't require any more handling than to
@@ -784,6 +1439,9 @@ func (dnl *decoderNL) decodeInterface(v interface{}) (interface{}, error) {
 		if err := dnl.DecodeBytes(&b); err != nil {
 			return nil, errors.Wrapf(err, `msgpack: failed to decode %s`, code)
 		}
+		if dnl.binAsString {
+			return string(b), nil
+		}
 		return b, nil
 	case IsStrFamily(code):
 		var s string
@@ -820,11 +1478,19 @@ func (dnl *decoderNL) decodeInterface(v interface{}) (interface{}, error) {
 			}
 		}
 
-		var v = make(map[string]interface{})
-		if err := dnl.DecodeMap(&v); err != nil {
+		if dnl.mapType == MapTypeOrdered {
+			var om OrderedMap
+			if err := om.DecodeMsgpack(dnl); err != nil {
+				return nil, errors.Wrap(err, `msgpack: failed to decode map`)
+			}
+			return om, nil
+		}
+
+		m, err := dnl.decodeGenericMap()
+		if err != nil {
 			return nil, errors.Wrap(err, `msgpack: failed to decode map`)
 		}
-		return v, nil
+		return m, nil
 	default:
 		return nil, errors.Errorf(`msgpack: invalid code %s`, code)
 	}
@@ -843,7 +1509,7 @@ func (dnl *decoderNL) DecodeExtLength(l *int) error {
 	case FixExt2:
 		payloadSize = 2
 	case FixExt4:
-		payloadSize = 1
+		payloadSize = 4
 	case FixExt8:
 		payloadSize = 8
 	case FixExt16:
@@ -870,7 +1536,7 @@ func (dnl *decoderNL) DecodeExtLength(l *int) error {
 		return errors.Errorf(`msgpack: invalid ext code %s`, code)
 	}
 	*l = payloadSize
-	return nil
+	return dnl.checkLength(int64(payloadSize))
 }
 
 func (dnl *decoderNL) DecodeExt(v DecodeMsgpacker) error {
@@ -895,19 +1561,191 @@ func (dnl *decoderNL) DecodeExt(v DecodeMsgpacker) error {
 }
 
 func (dnl *decoderNL) DecodeExtType(v *reflect.Type) error {
-	t, err := dnl.src.ReadUint8()
+	b, err := dnl.src.ReadByte()
 	if err != nil {
 		return errors.Wrap(err, `msgpack: failed to read type for extension`)
 	}
+	t := int8(b)
 
-	muExtDecode.Lock()
-	typ, ok := extDecodeRegistry[int(t)]
-	muExtDecode.Unlock()
+	muExtDecode.RLock()
+	typ, ok := extDecodeRegistry[t]
+	muExtDecode.RUnlock()
 
 	if !ok {
-		return errors.Errorf(`msgpack: type %d is not registered as an extension`, int(t))
+		return errors.Errorf(`msgpack: type %d is not registered as an extension`, t)
 	}
 
 	*v = typ
 	return nil
 }
+
+// Skip discards the next value in the stream, including all of its
+// elements if it is a container. The value is never materialized:
+// the structure is walked solely by its length prefixes.
+func (dnl *decoderNL) Skip() error {
+	return dnl.consumeValue(nil)
+}
+
+// decodeRaw reads the next value in the stream, including all of its
+// elements if it is a container, and stores its verbatim msgpack
+// representation in v
+func (dnl *decoderNL) decodeRaw(v *RawMessage) error {
+	if dnl.slice != nil && dnl.zeroCopy {
+		start := dnl.slice.pos
+		if err := dnl.consumeValue(nil); err != nil {
+			return err
+		}
+		end := dnl.slice.pos
+		*v = dnl.slice.buf[start:end:end]
+		return nil
+	}
+
+	buf := []byte((*v)[:0])
+	if err := dnl.consumeValue(&buf); err != nil {
+		return err
+	}
+	*v = buf
+	return nil
+}
+
+// consumeValue walks the next value in the stream by its length
+// prefixes. If dst is non-nil, the bytes that were consumed are
+// appended to it. Otherwise they are discarded.
+func (dnl *decoderNL) consumeValue(dst *[]byte) error {
+	// Instead of recursing into containers, keep track of the number
+	// of values that still need to be consumed
+	for remaining := 1; remaining > 0; remaining-- {
+		code, err := dnl.ReadCode()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read code`)
+		}
+		if dst != nil {
+			*dst = append(*dst, code.Byte())
+		}
+
+		var count int // number of nested values
+		var size int  // number of payload bytes
+		switch {
+		case IsFixNumFamily(code) || code == Nil || code == True || code == False:
+		case code >= FixMap0 && code <= FixMap15:
+			count = 2 * int(code.Byte()-FixMap0.Byte())
+		case code >= FixArray0 && code <= FixArray15:
+			count = int(code.Byte() - FixArray0.Byte())
+		case code >= FixStr0 && code <= FixStr31:
+			size = int(code.Byte() - FixStr0.Byte())
+		case code == Int8 || code == Uint8:
+			size = 1
+		case code == Int16 || code == Uint16:
+			size = 2
+		case code == Int32 || code == Uint32 || code == Float:
+			size = 4
+		case code == Int64 || code == Uint64 || code == Double:
+			size = 8
+		// extensions have an extra byte for the type
+		case code == FixExt1:
+			size = 1 + 1
+		case code == FixExt2:
+			size = 1 + 2
+		case code == FixExt4:
+			size = 1 + 4
+		case code == FixExt8:
+			size = 1 + 8
+		case code == FixExt16:
+			size = 1 + 16
+		case code == Bin8 || code == Str8:
+			size, err = dnl.consumeLength(dst, 1)
+		case code == Bin16 || code == Str16:
+			size, err = dnl.consumeLength(dst, 2)
+		case code == Bin32 || code == Str32:
+			size, err = dnl.consumeLength(dst, 4)
+		case code == Ext8:
+			size, err = dnl.consumeLength(dst, 1)
+			size++
+		case code == Ext16:
+			size, err = dnl.consumeLength(dst, 2)
+			size++
+		case code == Ext32:
+			size, err = dnl.consumeLength(dst, 4)
+			size++
+		case code == Array16:
+			count, err = dnl.consumeLength(dst, 2)
+		case code == Array32:
+			count, err = dnl.consumeLength(dst, 4)
+		case code == Map16:
+			count, err = dnl.consumeLength(dst, 2)
+			count *= 2
+		case code == Map32:
+			count, err = dnl.consumeLength(dst, 4)
+			count *= 2
+		default:
+			return errors.Errorf(`msgpack: invalid code %s`, code)
+		}
+		if err != nil {
+			return errors.Wrapf(err, `msgpack: failed to read length for %s`, code)
+		}
+
+		switch {
+		case IsBinFamily(code) || IsStrFamily(code):
+			err = dnl.checkLength(int64(size))
+		case IsExtFamily(code):
+			// size includes the type byte
+			err = dnl.checkLength(int64(size - 1))
+		case IsArrayFamily(code):
+			err = dnl.checkElements(count)
+		case IsMapFamily(code):
+			err = dnl.checkElements(count / 2)
+		}
+		if err != nil {
+			return err
+		}
+
+		if size > 0 {
+			if dst != nil {
+				l := len(*dst)
+				*dst = append(*dst, make([]byte, size)...)
+				if _, err := io.ReadFull(dnl.raw, (*dst)[l:]); err != nil {
+					return errors.Wrapf(err, `msgpack: failed to read payload for %s`, code)
+				}
+			} else {
+				if _, err := dnl.raw.Discard(size); err != nil {
+					return errors.Wrapf(err, `msgpack: failed to skip payload for %s`, code)
+				}
+			}
+		}
+		remaining += count
+	}
+	return nil
+}
+
+// consumeLength reads a big endian length prefix of w bytes, optionally
+// appending the raw bytes to dst
+func (dnl *decoderNL) consumeLength(dst *[]byte, w int) (int, error) {
+	var l int
+	switch w {
+	case 1:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return 0, err
+		}
+		l = int(x)
+	case 2:
+		x, err := dnl.src.ReadUint16()
+		if err != nil {
+			return 0, err
+		}
+		l = int(x)
+	case 4:
+		x, err := dnl.src.ReadUint32()
+		if err != nil {
+			return 0, err
+		}
+		l = int(x)
+	}
+
+	if dst != nil {
+		for i := w - 1; i >= 0; i-- {
+			*dst = append(*dst, byte(l>>(uint(i)*8)))
+		}
+	}
+	return l, nil
+}
@@ -109,6 +109,36 @@ func (d *decoder) Reader() Reader {
 	return d.nl.Reader()
 }
 
+func (d *decoder) Skip() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.nl.Skip()
+}
+
+func (d *decoder) Token() (Token, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.nl.Token()
+}
+
+func (d *decoder) InputOffset() int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.nl.InputOffset()
+}
+
+func (d *decoder) ArrayIterator() ArrayIterator {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.nl.ArrayIterator()
+}
+
+func (d *decoder) MapIterator() MapIterator {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.nl.MapIterator()
+}
+
 func (d *decoder) DecodeInt(v *int) error {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
@@ -14,7 +14,7 @@ func (dnl *decoderNL) DecodeInt(v *int) error {
 		return errors.Wrap(err, `msgpack: failed to read code for Int64`)
 	}
 	if IsFixNumFamily(Code(code)) {
-		*v = int(code)
+		*v = int(int8(code))
 		return nil
 	}
 	switch Code(code) {
@@ -30,16 +30,47 @@ func (dnl *decoderNL) DecodeInt(v *int) error {
 		if err != nil {
 			return errors.Wrap(err, `msgpack: failed to read payload for int`)
 		}
-		*v = int(x)
+		*v = int(int32(x))
 		return nil
 	case Int16:
 		x, err := dnl.src.ReadUint16()
 		if err != nil {
 			return errors.Wrap(err, `msgpack: failed to read payload for int`)
 		}
-		*v = int(x)
+		*v = int(int16(x))
 		return nil
 	case Int8:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int`)
+		}
+		*v = int(int8(x))
+		return nil
+	case Uint64:
+		x, err := dnl.src.ReadUint64()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int`)
+		}
+		if x > math.MaxInt64 {
+			return errors.Errorf(`msgpack: value %d out of range for int`, x)
+		}
+		*v = int(x)
+		return nil
+	case Uint32:
+		x, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int`)
+		}
+		*v = int(x)
+		return nil
+	case Uint16:
+		x, err := dnl.src.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int`)
+		}
+		*v = int(x)
+		return nil
+	case Uint8:
 		x, err := dnl.src.ReadUint8()
 		if err != nil {
 			return errors.Wrap(err, `msgpack: failed to read payload for int`)
@@ -56,7 +87,7 @@ func (dnl *decoderNL) DecodeInt8(v *int8) error {
 		return errors.Wrap(err, `msgpack: failed to read code for Int8`)
 	}
 	if IsFixNumFamily(Code(code)) {
-		*v = int8(code)
+		*v = int8(int8(code))
 		return nil
 	}
 	switch Code(code) {
@@ -67,6 +98,46 @@ func (dnl *decoderNL) DecodeInt8(v *int8) error {
 		}
 		*v = int8(x)
 		return nil
+	case Uint64:
+		x, err := dnl.src.ReadUint64()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int8`)
+		}
+		if x > math.MaxInt8 {
+			return errors.Errorf(`msgpack: value %d out of range for int8`, x)
+		}
+		*v = int8(x)
+		return nil
+	case Uint32:
+		x, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int8`)
+		}
+		if x > math.MaxInt8 {
+			return errors.Errorf(`msgpack: value %d out of range for int8`, x)
+		}
+		*v = int8(x)
+		return nil
+	case Uint16:
+		x, err := dnl.src.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int8`)
+		}
+		if x > math.MaxInt8 {
+			return errors.Errorf(`msgpack: value %d out of range for int8`, x)
+		}
+		*v = int8(x)
+		return nil
+	case Uint8:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int8`)
+		}
+		if x > math.MaxInt8 {
+			return errors.Errorf(`msgpack: value %d out of range for int8`, x)
+		}
+		*v = int8(x)
+		return nil
 	}
 	return errors.Errorf(`msgpack: invalid numeric type %s for int8`, Code(code))
 }
@@ -77,7 +148,7 @@ func (dnl *decoderNL) DecodeInt16(v *int16) error {
 		return errors.Wrap(err, `msgpack: failed to read code for Int16`)
 	}
 	if IsFixNumFamily(Code(code)) {
-		*v = int16(code)
+		*v = int16(int8(code))
 		return nil
 	}
 	switch Code(code) {
@@ -89,6 +160,43 @@ func (dnl *decoderNL) DecodeInt16(v *int16) error {
 		*v = int16(x)
 		return nil
 	case Int8:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int16`)
+		}
+		*v = int16(int8(x))
+		return nil
+	case Uint64:
+		x, err := dnl.src.ReadUint64()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int16`)
+		}
+		if x > math.MaxInt16 {
+			return errors.Errorf(`msgpack: value %d out of range for int16`, x)
+		}
+		*v = int16(x)
+		return nil
+	case Uint32:
+		x, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int16`)
+		}
+		if x > math.MaxInt16 {
+			return errors.Errorf(`msgpack: value %d out of range for int16`, x)
+		}
+		*v = int16(x)
+		return nil
+	case Uint16:
+		x, err := dnl.src.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int16`)
+		}
+		if x > math.MaxInt16 {
+			return errors.Errorf(`msgpack: value %d out of range for int16`, x)
+		}
+		*v = int16(x)
+		return nil
+	case Uint8:
 		x, err := dnl.src.ReadUint8()
 		if err != nil {
 			return errors.Wrap(err, `msgpack: failed to read payload for int16`)
@@ -105,7 +213,7 @@ func (dnl *decoderNL) DecodeInt32(v *int32) error {
 		return errors.Wrap(err, `msgpack: failed to read code for Int32`)
 	}
 	if IsFixNumFamily(Code(code)) {
-		*v = int32(code)
+		*v = int32(int8(code))
 		return nil
 	}
 	switch Code(code) {
@@ -121,9 +229,43 @@ func (dnl *decoderNL) DecodeInt32(v *int32) error {
 		if err != nil {
 			return errors.Wrap(err, `msgpack: failed to read payload for int32`)
 		}
-		*v = int32(x)
+		*v = int32(int16(x))
 		return nil
 	case Int8:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int32`)
+		}
+		*v = int32(int8(x))
+		return nil
+	case Uint64:
+		x, err := dnl.src.ReadUint64()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int32`)
+		}
+		if x > math.MaxInt32 {
+			return errors.Errorf(`msgpack: value %d out of range for int32`, x)
+		}
+		*v = int32(x)
+		return nil
+	case Uint32:
+		x, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int32`)
+		}
+		if x > math.MaxInt32 {
+			return errors.Errorf(`msgpack: value %d out of range for int32`, x)
+		}
+		*v = int32(x)
+		return nil
+	case Uint16:
+		x, err := dnl.src.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int32`)
+		}
+		*v = int32(x)
+		return nil
+	case Uint8:
 		x, err := dnl.src.ReadUint8()
 		if err != nil {
 			return errors.Wrap(err, `msgpack: failed to read payload for int32`)
@@ -140,7 +282,7 @@ func (dnl *decoderNL) DecodeInt64(v *int64) error {
 		return errors.Wrap(err, `msgpack: failed to read code for Int64`)
 	}
 	if IsFixNumFamily(Code(code)) {
-		*v = int64(code)
+		*v = int64(int8(code))
 		return nil
 	}
 	switch Code(code) {
@@ -156,16 +298,47 @@ func (dnl *decoderNL) DecodeInt64(v *int64) error {
 		if err != nil {
 			return errors.Wrap(err, `msgpack: failed to read payload for int64`)
 		}
-		*v = int64(x)
+		*v = int64(int32(x))
 		return nil
 	case Int16:
 		x, err := dnl.src.ReadUint16()
 		if err != nil {
 			return errors.Wrap(err, `msgpack: failed to read payload for int64`)
 		}
-		*v = int64(x)
+		*v = int64(int16(x))
 		return nil
 	case Int8:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int64`)
+		}
+		*v = int64(int8(x))
+		return nil
+	case Uint64:
+		x, err := dnl.src.ReadUint64()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int64`)
+		}
+		if x > math.MaxInt64 {
+			return errors.Errorf(`msgpack: value %d out of range for int64`, x)
+		}
+		*v = int64(x)
+		return nil
+	case Uint32:
+		x, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int64`)
+		}
+		*v = int64(x)
+		return nil
+	case Uint16:
+		x, err := dnl.src.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for int64`)
+		}
+		*v = int64(x)
+		return nil
+	case Uint8:
 		x, err := dnl.src.ReadUint8()
 		if err != nil {
 			return errors.Wrap(err, `msgpack: failed to read payload for int64`)
@@ -182,10 +355,53 @@ func (dnl *decoderNL) DecodeUint(v *uint) error {
 		return errors.Wrap(err, `msgpack: failed to read code for Uint64`)
 	}
 	if IsFixNumFamily(Code(code)) {
+		if int8(code) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint`, int8(code))
+		}
 		*v = uint(code)
 		return nil
 	}
 	switch Code(code) {
+	case Int64:
+		x, err := dnl.src.ReadUint64()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint`)
+		}
+		if int64(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint`, int64(x))
+		}
+		*v = uint(x)
+		return nil
+	case Int32:
+		x, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint`)
+		}
+		if int32(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint`, int32(x))
+		}
+		*v = uint(x)
+		return nil
+	case Int16:
+		x, err := dnl.src.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint`)
+		}
+		if int16(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint`, int16(x))
+		}
+		*v = uint(x)
+		return nil
+	case Int8:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint`)
+		}
+		if int8(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint`, int8(x))
+		}
+		*v = uint(x)
+		return nil
 	case Uint64:
 		x, err := dnl.src.ReadUint64()
 		if err != nil {
@@ -224,10 +440,23 @@ func (dnl *decoderNL) DecodeUint8(v *uint8) error {
 		return errors.Wrap(err, `msgpack: failed to read code for Uint8`)
 	}
 	if IsFixNumFamily(Code(code)) {
+		if int8(code) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint8`, int8(code))
+		}
 		*v = uint8(code)
 		return nil
 	}
 	switch Code(code) {
+	case Int8:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint8`)
+		}
+		if int8(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint8`, int8(x))
+		}
+		*v = uint8(x)
+		return nil
 	case Uint8:
 		x, err := dnl.src.ReadUint8()
 		if err != nil {
@@ -245,10 +474,33 @@ func (dnl *decoderNL) DecodeUint16(v *uint16) error {
 		return errors.Wrap(err, `msgpack: failed to read code for Uint16`)
 	}
 	if IsFixNumFamily(Code(code)) {
+		if int8(code) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint16`, int8(code))
+		}
 		*v = uint16(code)
 		return nil
 	}
 	switch Code(code) {
+	case Int16:
+		x, err := dnl.src.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint16`)
+		}
+		if int16(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint16`, int16(x))
+		}
+		*v = uint16(x)
+		return nil
+	case Int8:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint16`)
+		}
+		if int8(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint16`, int8(x))
+		}
+		*v = uint16(x)
+		return nil
 	case Uint16:
 		x, err := dnl.src.ReadUint16()
 		if err != nil {
@@ -273,10 +525,43 @@ func (dnl *decoderNL) DecodeUint32(v *uint32) error {
 		return errors.Wrap(err, `msgpack: failed to read code for Uint32`)
 	}
 	if IsFixNumFamily(Code(code)) {
+		if int8(code) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint32`, int8(code))
+		}
 		*v = uint32(code)
 		return nil
 	}
 	switch Code(code) {
+	case Int32:
+		x, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint32`)
+		}
+		if int32(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint32`, int32(x))
+		}
+		*v = uint32(x)
+		return nil
+	case Int16:
+		x, err := dnl.src.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint32`)
+		}
+		if int16(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint32`, int16(x))
+		}
+		*v = uint32(x)
+		return nil
+	case Int8:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint32`)
+		}
+		if int8(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint32`, int8(x))
+		}
+		*v = uint32(x)
+		return nil
 	case Uint32:
 		x, err := dnl.src.ReadUint32()
 		if err != nil {
@@ -308,10 +593,53 @@ func (dnl *decoderNL) DecodeUint64(v *uint64) error {
 		return errors.Wrap(err, `msgpack: failed to read code for Uint64`)
 	}
 	if IsFixNumFamily(Code(code)) {
+		if int8(code) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint64`, int8(code))
+		}
 		*v = uint64(code)
 		return nil
 	}
 	switch Code(code) {
+	case Int64:
+		x, err := dnl.src.ReadUint64()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint64`)
+		}
+		if int64(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint64`, int64(x))
+		}
+		*v = uint64(x)
+		return nil
+	case Int32:
+		x, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint64`)
+		}
+		if int32(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint64`, int32(x))
+		}
+		*v = uint64(x)
+		return nil
+	case Int16:
+		x, err := dnl.src.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint64`)
+		}
+		if int16(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint64`, int16(x))
+		}
+		*v = uint64(x)
+		return nil
+	case Int8:
+		x, err := dnl.src.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for uint64`)
+		}
+		if int8(x) < 0 {
+			return errors.Errorf(`msgpack: value %d out of range for uint64`, int8(x))
+		}
+		*v = uint64(x)
+		return nil
 	case Uint64:
 		x, err := dnl.src.ReadUint64()
 		if err != nil {
@@ -359,15 +687,26 @@ func (dnl *decoderNL) DecodeFloat32(v *float32) error {
 }
 
 func (dnl *decoderNL) DecodeFloat64(v *float64) error {
-	code, x, err := dnl.src.ReadByteUint64()
+	code, err := dnl.src.ReadUint8()
 	if err != nil {
 		return errors.Wrap(err, `msgpack: failed to read float64`)
 	}
 
-	if code != Double.Byte() {
+	switch code {
+	case Double.Byte():
+		x, err := dnl.src.ReadUint64()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read float64`)
+		}
+		*v = math.Float64frombits(x)
+	case Float.Byte():
+		x, err := dnl.src.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read float64`)
+		}
+		*v = float64(math.Float32frombits(x))
+	default:
 		return errors.Errorf(`msgpack: expected Double, got %s`, Code(code))
 	}
-
-	*v = math.Float64frombits(x)
 	return nil
 }
@@ -8,8 +8,10 @@ import (
 	"math"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/lestrrat-go/msgpack"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -399,3 +401,546 @@ func TestDecodeNestedStruct(t *testing.T) {
 		}
 	})
 }
+
+func TestDecodeStructUnknownFields(t *testing.T) {
+	t.Parallel()
+
+	b, err := msgpack.Marshal(map[string]interface{}{
+		"Unknown": []interface{}{1, "two", map[string]interface{}{"three": 3}},
+	})
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+
+	// Append a known field after the unknown one
+	b[0] = msgpack.FixMap2.Byte()
+	b = append(b, msgpack.FixStr0.Byte()+3)
+	b = append(b, []byte("Foo")...)
+	b = append(b, msgpack.FixStr0.Byte()+5)
+	b = append(b, []byte("Hello")...)
+
+	var v nestedInner
+	if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+		return
+	}
+	if !assert.Equal(t, "Hello", v.Foo, "known field should be decoded") {
+		return
+	}
+}
+
+func TestDecodeStructDisallowUnknownFields(t *testing.T) {
+	t.Parallel()
+
+	b, err := msgpack.Marshal(map[string]interface{}{"Fooo": "Hello"})
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+
+	var v nestedInner
+	if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed by default") {
+		return
+	}
+
+	err = msgpack.Unmarshal(b, &v, msgpack.WithDisallowUnknownFields(true))
+	if !assert.Error(t, err, "Unmarshal should fail") {
+		return
+	}
+
+	ufe, ok := errors.Cause(err).(*msgpack.UnknownFieldError)
+	if !assert.True(t, ok, "error should be an UnknownFieldError") {
+		return
+	}
+	assert.Equal(t, "Fooo", ufe.Key, "Key should match")
+	assert.Equal(t, reflect.TypeOf(v), ufe.Type, "Type should match")
+	assert.Contains(t, err.Error(), `unknown field "Fooo" for msgpack_test.nestedInner`, "error message should name the key and type")
+
+	// Unknown fields in nested structs are reported too
+	b, err = msgpack.Marshal(map[string]interface{}{
+		"InnerStruct": map[string]interface{}{"Bar": "Hello"},
+	})
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+
+	var outer nestedOuter
+	err = msgpack.Unmarshal(b, &outer, msgpack.WithDisallowUnknownFields(true))
+	if !assert.Error(t, err, "Unmarshal should fail") {
+		return
+	}
+	ufe, ok = errors.Cause(err).(*msgpack.UnknownFieldError)
+	if !assert.True(t, ok, "error should be an UnknownFieldError") {
+		return
+	}
+	assert.Equal(t, reflect.TypeOf(nestedInner{}), ufe.Type, "Type should match")
+}
+
+type aliasedStruct struct {
+	UserID string `msgpack:"user_id,alias=uid|userId"`
+	Name   string `msgpack:"name,omitempty,alias=fullName"`
+}
+
+func TestDecodeStructFieldMatching(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		Name     string
+		Input    map[string]interface{}
+		Options  []msgpack.Option
+		Expected aliasedStruct
+	}{
+		{
+			Name:     "exact names",
+			Input:    map[string]interface{}{"user_id": "foo", "name": "bar"},
+			Expected: aliasedStruct{UserID: "foo", Name: "bar"},
+		},
+		{
+			Name:     "aliases",
+			Input:    map[string]interface{}{"uid": "foo", "fullName": "bar"},
+			Expected: aliasedStruct{UserID: "foo", Name: "bar"},
+		},
+		{
+			Name:     "second alias",
+			Input:    map[string]interface{}{"userId": "foo"},
+			Expected: aliasedStruct{UserID: "foo"},
+		},
+		{
+			Name:     "case mismatch is ignored by default",
+			Input:    map[string]interface{}{"USER_ID": "foo", "Name": "bar"},
+			Expected: aliasedStruct{},
+		},
+		{
+			Name:     "case-insensitive",
+			Input:    map[string]interface{}{"USER_ID": "foo", "Name": "bar"},
+			Options:  []msgpack.Option{msgpack.WithCaseInsensitiveFields(true)},
+			Expected: aliasedStruct{UserID: "foo", Name: "bar"},
+		},
+		{
+			Name:     "case-insensitive alias",
+			Input:    map[string]interface{}{"USERID": "foo"},
+			Options:  []msgpack.Option{msgpack.WithCaseInsensitiveFields(true)},
+			Expected: aliasedStruct{UserID: "foo"},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			b, err := msgpack.Marshal(tc.Input)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+
+			var v aliasedStruct
+			if !assert.NoError(t, msgpack.Unmarshal(b, &v, tc.Options...), "Unmarshal should succeed") {
+				return
+			}
+			assert.Equal(t, tc.Expected, v, "decoded value should match")
+		})
+	}
+
+	t.Run("aliases are not used for encoding", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(aliasedStruct{UserID: "foo"})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var m map[string]interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &m), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, map[string]interface{}{"user_id": "foo"}, m, "encoded keys should match")
+	})
+}
+
+// Note: AllocsPerRun cannot be used in parallel tests
+func TestDecodeStructFieldMatchingAllocs(t *testing.T) {
+	const runs = 100
+
+	allocs := func(input map[string]interface{}) float64 {
+		var data []byte
+		for i := 0; i < runs+1; i++ {
+			b, err := msgpack.Marshal(input)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return -1
+			}
+			data = append(data, b...)
+		}
+
+		dec := msgpack.NewDecoderNoLock(bytes.NewReader(data), msgpack.WithCaseInsensitiveFields(true))
+		var v struct {
+			UserID int `msgpack:"user_id"`
+		}
+		return testing.AllocsPerRun(runs, func() {
+			if err := dec.Decode(&v); err != nil {
+				panic(err)
+			}
+		})
+	}
+
+	// Decoding the key itself allocates, but looking it up should not
+	exact := allocs(map[string]interface{}{"user_id": 1})
+	assert.Equal(t, exact, allocs(map[string]interface{}{"USER_ID": 1}), "mismatched case should not allocate")
+	assert.Equal(t, exact, allocs(map[string]interface{}{"unknown": 1}), "unknown keys should not allocate")
+}
+
+func TestDecodeTime(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		Name     string
+		Data     []byte
+		Expected time.Time
+	}{
+		{
+			Name:     "timestamp 32",
+			Data:     []byte{msgpack.FixExt4.Byte(), 0xff, 0x49, 0x96, 0x02, 0xd2},
+			Expected: time.Unix(1234567890, 0),
+		},
+		{
+			Name:     "timestamp 64",
+			Data:     []byte{msgpack.FixExt8.Byte(), 0xff, 0x00, 0x00, 0x01, 0xec, 0x49, 0x96, 0x02, 0xd2},
+			Expected: time.Unix(1234567890, 123),
+		},
+		{
+			Name:     "timestamp 96",
+			Data:     []byte{msgpack.Ext8.Byte(), 12, 0xff, 0x00, 0x00, 0x00, 0x7b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			Expected: time.Unix(-1, 123),
+		},
+		{
+			Name:     "legacy array",
+			Data:     []byte{msgpack.FixArray2.Byte(), msgpack.Int64.Byte(), 0, 0, 0, 0, 0x49, 0x96, 0x02, 0xd2, 0x7b},
+			Expected: time.Unix(1234567890, 123),
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			var v time.Time
+			if !assert.NoError(t, msgpack.Unmarshal(tc.Data, &v), "Unmarshal should succeed") {
+				return
+			}
+			if !assert.True(t, tc.Expected.Equal(v), "value should be %s (got %s)", tc.Expected, v) {
+				return
+			}
+		})
+	}
+
+	t.Run("decode into interface{}", func(t *testing.T) {
+		t.Parallel()
+		var v interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(testcases[1].Data, &v), "Unmarshal should succeed") {
+			return
+		}
+		tv, ok := v.(time.Time)
+		if !assert.True(t, ok, "value should be a time.Time") {
+			return
+		}
+		if !assert.True(t, testcases[1].Expected.Equal(tv), "value should match") {
+			return
+		}
+	})
+}
+
+func TestDecoderSkip(t *testing.T) {
+	t.Parallel()
+
+	values := []interface{}{
+		nil,
+		true,
+		int8(-1),
+		int8(100),
+		int64(math.MinInt64),
+		uint64(math.MaxUint64),
+		float32(math.MaxFloat32),
+		float64(math.MaxFloat64),
+		"Hello, World!",
+		makeString(math.MaxUint8 + 1),
+		[]byte("Hello, World!"),
+		[]interface{}{1, "two", []interface{}{3.0, nil}},
+		map[string]interface{}{"foo": map[string]interface{}{"bar": []string{"baz"}}},
+		time.Unix(1234567890, 123),
+		negativeExt{Value: 0xcafe},
+	}
+
+	for _, value := range values {
+		value := value
+		t.Run(fmt.Sprintf("%T", value), func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			enc := msgpack.NewEncoder(&buf)
+			if !assert.NoError(t, enc.Encode(value), "Encode should succeed") {
+				return
+			}
+			if !assert.NoError(t, enc.EncodeString("sentinel"), "EncodeString should succeed") {
+				return
+			}
+
+			dec := msgpack.NewDecoder(&buf)
+			if !assert.NoError(t, dec.Skip(), "Skip should succeed") {
+				return
+			}
+
+			var s string
+			if !assert.NoError(t, dec.DecodeString(&s), "DecodeString should succeed") {
+				return
+			}
+			if !assert.Equal(t, "sentinel", s, "value after skipped value should be decoded") {
+				return
+			}
+		})
+	}
+}
+
+// Note: AllocsPerRun cannot be used in parallel tests
+func TestDecoderSkipAllocs(t *testing.T) {
+	const runs = 100
+	value := map[string]interface{}{"foo": []interface{}{1, "two", []byte("three")}}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	for i := 0; i < runs+1; i++ {
+		if !assert.NoError(t, enc.Encode(value), "Encode should succeed") {
+			return
+		}
+	}
+
+	dec := msgpack.NewDecoderNoLock(&buf)
+	allocs := testing.AllocsPerRun(runs, func() {
+		if err := dec.Skip(); err != nil {
+			panic(err)
+		}
+	})
+	if !assert.Zero(t, allocs, "Skip should not allocate") {
+		return
+	}
+}
+
+func TestDecodeMapKeyMode(t *testing.T) {
+	t.Parallel()
+
+	b, err := msgpack.Marshal(map[interface{}]interface{}{
+		int64(1): "one",
+	})
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+
+	t.Run("all string keys", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(map[interface{}]interface{}{"one": int64(1)})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		var v interface{}
+		unmarshalMatch(t, b, &v, map[string]interface{}{"one": int64(1)})
+	})
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		var v interface{}
+		unmarshalMatch(t, b, &v, map[interface{}]interface{}{int64(1): "one"})
+	})
+	t.Run("MapKeyStringify", func(t *testing.T) {
+		t.Parallel()
+		var v interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v, msgpack.WithMapKeyMode(msgpack.MapKeyStringify)), "Unmarshal should succeed") {
+			return
+		}
+		if !assert.Equal(t, map[string]interface{}{"1": "one"}, v, "keys should be converted to strings") {
+			return
+		}
+	})
+	t.Run("MapKeyStrict", func(t *testing.T) {
+		t.Parallel()
+		var v interface{}
+		if !assert.Error(t, msgpack.Unmarshal(b, &v, msgpack.WithMapKeyMode(msgpack.MapKeyStrict)), "Unmarshal should fail") {
+			return
+		}
+	})
+}
+
+func TestDecodeInterfaceDefaults(t *testing.T) {
+	t.Parallel()
+
+	// A map with keys in a known order: {"b": bin("foo"), "a": {"c": 1}}
+	b := []byte{msgpack.FixMap2.Byte(),
+		msgpack.FixStr0.Byte() + 1, 'b', msgpack.Bin8.Byte(), 3, 'f', 'o', 'o',
+		msgpack.FixStr0.Byte() + 1, 'a', msgpack.FixMap1.Byte(), msgpack.FixStr0.Byte() + 1, 'c', 0x1,
+	}
+
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		var v interface{}
+		unmarshalMatch(t, b, &v, map[string]interface{}{
+			"b": []byte("foo"),
+			"a": map[string]interface{}{"c": int8(1)},
+		})
+	})
+	t.Run("MapTypeInterface", func(t *testing.T) {
+		t.Parallel()
+		var v interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v, msgpack.WithMapType(msgpack.MapTypeInterface)), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, map[interface{}]interface{}{
+			"b": []byte("foo"),
+			"a": map[interface{}]interface{}{"c": int8(1)},
+		}, v, "maps should be map[interface{}]interface{}")
+	})
+	t.Run("MapTypeOrdered", func(t *testing.T) {
+		t.Parallel()
+		var v interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v, msgpack.WithMapType(msgpack.MapTypeOrdered)), "Unmarshal should succeed") {
+			return
+		}
+		expected := msgpack.OrderedMap{
+			{Key: "b", Value: []byte("foo")},
+			{Key: "a", Value: msgpack.OrderedMap{{Key: "c", Value: int8(1)}}},
+		}
+		if !assert.Equal(t, expected, v, "maps should be OrderedMap") {
+			return
+		}
+
+		// Encoding preserves the order
+		encoded, err := msgpack.Marshal(v)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		var om msgpack.OrderedMap
+		if !assert.NoError(t, msgpack.Unmarshal(encoded, &om), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, []interface{}{"b", "a"}, om.Keys(), "order should be preserved")
+
+		value, ok := expected.Get("a")
+		if assert.True(t, ok, "Get should succeed") {
+			assert.Equal(t, msgpack.OrderedMap{{Key: "c", Value: int8(1)}}, value, "Get should return the value")
+		}
+		assert.Equal(t, []interface{}{"b", "a"}, expected.Keys(), "Keys should be in order")
+	})
+	t.Run("MapTypeOrdered with map keys", func(t *testing.T) {
+		t.Parallel()
+		// {{1: 2}: 3}
+		b := []byte{msgpack.FixMap1.Byte(), msgpack.FixMap1.Byte(), 0x1, 0x2, 0x3}
+
+		m := map[interface{}]interface{}{}
+		assert.Error(t, msgpack.Unmarshal(b, &m, msgpack.WithMapType(msgpack.MapTypeOrdered)), "unhashable keys should be rejected")
+	})
+	t.Run("all options", func(t *testing.T) {
+		t.Parallel()
+		var v interface{}
+		options := []msgpack.Option{
+			msgpack.WithMapType(msgpack.MapTypeInterface),
+			msgpack.WithBinAsString(true),
+			msgpack.WithNormalizeIntegers(true),
+		}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v, options...), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, map[interface{}]interface{}{
+			"b": "foo",
+			"a": map[interface{}]interface{}{"c": int64(1)},
+		}, v, "values should match")
+	})
+}
+
+func TestDecoderBytes(t *testing.T) {
+	t.Parallel()
+
+	type payload struct {
+		Name string
+		Data []byte
+		Raw  msgpack.RawMessage
+	}
+
+	raw, err := msgpack.Marshal([]int{1, 2, 3})
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+	src := payload{Name: "foo", Data: []byte("bar"), Raw: raw}
+
+	t.Run("sequential values", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		for _, v := range []interface{}{src, "Hello", int64(42)} {
+			if !assert.NoError(t, enc.Encode(v), "Encode should succeed") {
+				return
+			}
+		}
+
+		dec := msgpack.NewDecoderBytes(buf.Bytes())
+		var p payload
+		if assert.NoError(t, dec.Decode(&p), "Decode should succeed") {
+			assert.Equal(t, src, p, "struct should match")
+		}
+		var s string
+		if assert.NoError(t, dec.DecodeString(&s), "DecodeString should succeed") {
+			assert.Equal(t, "Hello", s, "string should match")
+		}
+		var i int64
+		if assert.NoError(t, dec.DecodeInt64(&i), "DecodeInt64 should succeed") {
+			assert.Equal(t, int64(42), i, "int64 should match")
+		}
+		err := dec.DecodeInt64(&i)
+		assert.Equal(t, io.EOF, errors.Cause(err), "reading past the end should return io.EOF")
+	})
+	t.Run("truncated input", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(src)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		var p payload
+		err = msgpack.NewDecoderBytes(b[:len(b)-2]).Decode(&p)
+		assert.Equal(t, io.ErrUnexpectedEOF, errors.Cause(err), "truncated input should return io.ErrUnexpectedEOF")
+	})
+	t.Run("aliasing", func(t *testing.T) {
+		t.Parallel()
+		for _, zeroCopy := range []bool{false, true} {
+			b, err := msgpack.Marshal(src)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+
+			var p payload
+			if !assert.NoError(t, msgpack.NewDecoderBytes(b, msgpack.WithZeroCopy(zeroCopy)).Decode(&p), "Decode should succeed") {
+				return
+			}
+			if !assert.Equal(t, src, p, "struct should match") {
+				return
+			}
+
+			// Scribble over the input, and see if the results change
+			for i := range b {
+				b[i] = 0
+			}
+			if zeroCopy {
+				assert.Equal(t, []byte{0, 0, 0}, p.Data, "[]byte should alias the input")
+				assert.Equal(t, "\x00\x00\x00", p.Name, "string should alias the input")
+				assert.Equal(t, make(msgpack.RawMessage, len(raw)), p.Raw, "RawMessage should alias the input")
+
+				// Appending to the results must not overwrite the input
+				p.Data = append(p.Data, 'x')
+				assert.Equal(t, make([]byte, len(b)), b, "input should not be modified")
+			} else {
+				assert.Equal(t, src, p, "results should not alias the input")
+			}
+		}
+	})
+	t.Run("total bytes", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(src)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var p payload
+		err = msgpack.NewDecoderBytes(b, msgpack.WithMaxTotalBytes(int64(len(b)-1))).Decode(&p)
+		assertLimitError(t, err, msgpack.LimitTotalBytes)
+		assert.NoError(t, msgpack.NewDecoderBytes(b, msgpack.WithMaxTotalBytes(int64(len(b)))).Decode(&p), "decoding within the limit should succeed")
+	})
+}
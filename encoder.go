@@ -1,23 +1,36 @@
 package msgpack
 
 import (
+	"bufio"
+	"bytes"
+	"encoding"
 	"io"
 	"math"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
+// DefaultEncoderBufferSize is the size of the buffer that Encoders
+// use for writing to an io.Writer, unless overridden by WithBufferSize
+const DefaultEncoderBufferSize = 4096
+
 // NewEncoder creates a new Encoder that writes serialized forms
 // to the specified io.Writer
 //
+// Writes to w are buffered, so Flush must be called once the values
+// have been encoded. The buffer is not used if w is already buffered
+// (e.g. *bufio.Writer and *bytes.Buffer), or if buffering has been
+// disabled using WithBufferSize(0).
+//
 // Note that Encoders are NEVER meant to be shared concurrently
 // between goroutines. You DO NOT write serialized data concurrently
 // to the same destination.
-func NewEncoder(w io.Writer) Encoder {
-	enc := &encoder{nl: &encoderNL{}}
+func NewEncoder(w io.Writer, options ...Option) Encoder {
+	enc := &encoder{nl: newEncoderNL(options)}
 	enc.nl.SetDestination(w)
 	return enc
 }
@@ -27,27 +40,98 @@ func NewEncoder(w io.Writer) Encoder {
 // method access. If you have complete control over the usage of
 // this object, then the object returned by this constructor will
 // shorten a whopping 30~50ns per method call. Use at your own peril
-func NewEncoderNoLock(w io.Writer) Encoder {
-	enc := &encoderNL{}
+func NewEncoderNoLock(w io.Writer, options ...Option) Encoder {
+	enc := newEncoderNL(options)
 	enc.SetDestination(w)
 	return enc
 }
 
+func newEncoderNL(options []Option) *encoderNL {
+	enc := &encoderNL{
+		bufferSize:     DefaultEncoderBufferSize,
+		spillThreshold: DefaultSpillThreshold,
+	}
+	for _, option := range options {
+		switch option.Name() {
+		case optkeyBufferSize:
+			enc.bufferSize = option.Value().(int)
+		case optkeyCanonical:
+			enc.canonical = option.Value().(bool)
+		case optkeySpillThreshold:
+			enc.spillThreshold = option.Value().(int)
+		case optkeyStructAsArray:
+			enc.structAsArray = option.Value().(bool)
+		}
+	}
+	return enc
+}
+
 func (e *encoder) SetDestination(r io.Writer) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.nl.SetDestination(r)
 }
 
+// SetDestination changes the destination of the Encoder. Any data
+// that has not been flushed to the previous destination is discarded
 func (enl *encoderNL) SetDestination(w io.Writer) {
-	var dst Writer
+	enl.patcher, _ = w.(patchableWriter)
+	enl.stream = nil
+
 	if x, ok := w.(Writer); ok {
-		dst = x
+		enl.dst = x
+		enl.buf = nil
+		return
+	}
+
+	if enl.bufferSize <= 0 || isBufferedWriter(w) {
+		enl.dst = NewWriter(w)
+		enl.buf = nil
+		return
+	}
+
+	if enl.buf == nil {
+		enl.buf = bufio.NewWriterSize(w, enl.bufferSize)
 	} else {
-		dst = NewWriter(w)
+		enl.buf.Reset(w)
+	}
+	enl.dst = NewWriter(enl.buf)
+}
+
+// newLocal creates an unbuffered Encoder that writes to w, using the
+// same settings as enl. It is used to encode values that need to be
+// written somewhere else first
+func (enl *encoderNL) newLocal(w Writer) *encoderNL {
+	return &encoderNL{
+		dst:            w,
+		canonical:      enl.canonical,
+		structAsArray:  enl.structAsArray,
+		spillThreshold: enl.spillThreshold,
 	}
+}
 
-	enl.dst = dst
+// isBufferedWriter returns true if writing to w in small chunks is
+// cheap enough that it does not need to be buffered
+func isBufferedWriter(w io.Writer) bool {
+	switch w.(type) {
+	case *bufio.Writer, *bufio.ReadWriter, *bytes.Buffer, *strings.Builder:
+		return true
+	}
+	return false
+}
+
+// Flush writes any buffered data to the destination. It is a no-op
+// if the Encoder is not buffering its output. Note that if the
+// destination is itself buffered (e.g. a *bufio.Writer), it is not
+// flushed
+func (enl *encoderNL) Flush() error {
+	if enl.buf == nil {
+		return nil
+	}
+	if err := enl.buf.Flush(); err != nil {
+		return errors.Wrap(err, `msgpack: failed to flush buffer`)
+	}
+	return nil
 }
 
 func inPositiveFixNumRange(i int64) bool {
@@ -65,8 +149,61 @@ func isExtType(t reflect.Type) bool {
 	return ok
 }
 
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// isTimeType returns true for time.Time and *time.Time. They implement
+// encoding.BinaryMarshaler and friends, but are encoded using the
+// timestamp extension instead
+func isTimeType(t reflect.Type) bool {
+	return t == timeType || t == timePtrType
+}
+
+// encoderInterfaces records which of the interfaces that take over
+// encoding are implemented by a type
+type encoderInterfaces uint8
+
+const (
+	implementsEncodeMsgpacker encoderInterfaces = 1 << iota
+	// encoding.BinaryMarshaler or encoding.TextMarshaler
+	implementsMarshaler
+)
+
+var muEncoderInterfaces sync.RWMutex
+var encoderInterfacesRegistry = make(map[reflect.Type]encoderInterfaces)
+
+// getEncoderInterfaces returns the interfaces implemented by t. The
+// result is cached, as Implements is too expensive to call for every
+// encoded value
+func getEncoderInterfaces(t reflect.Type) encoderInterfaces {
+	muEncoderInterfaces.RLock()
+	ifaces, ok := encoderInterfacesRegistry[t]
+	muEncoderInterfaces.RUnlock()
+	if ok {
+		return ifaces
+	}
+
+	if t.Implements(encodeMsgpackerType) {
+		ifaces |= implementsEncodeMsgpacker
+	}
+	if !isTimeType(t) && (t.Implements(binaryMarshalerType) || t.Implements(textMarshalerType)) {
+		ifaces |= implementsMarshaler
+	}
+
+	muEncoderInterfaces.Lock()
+	encoderInterfacesRegistry[t] = ifaces
+	muEncoderInterfaces.Unlock()
+	return ifaces
+}
+
 func isEncodeMsgpacker(t reflect.Type) bool {
-	return t.Implements(encodeMsgpackerType)
+	return getEncoderInterfaces(t)&implementsEncodeMsgpacker != 0
+}
+
+// isMarshaler returns true if t implements encoding.BinaryMarshaler
+// or encoding.TextMarshaler
+func isMarshaler(t reflect.Type) bool {
+	return getEncoderInterfaces(t)&implementsMarshaler != 0
 }
 
 func (enl *encoderNL) Writer() Writer {
@@ -78,6 +215,8 @@ func (enl *encoderNL) encodeBuiltin(v interface{}) (error, bool) {
 	switch v := v.(type) {
 	case string:
 		return enl.EncodeString(v), true
+	case RawMessage:
+		return enl.encodeRaw(v), true
 	case []byte:
 		return enl.EncodeBytes(v), true
 	case bool:
@@ -106,6 +245,10 @@ func (enl *encoderNL) encodeBuiltin(v interface{}) (error, bool) {
 		return enl.EncodeInt32(v), true
 	case int64:
 		return enl.EncodeInt64(v), true
+	case Number:
+		if enl.canonical {
+			return enl.encodeCanonicalNumber(v), true
+		}
 	}
 
 	return nil, false
@@ -137,6 +280,11 @@ INDIRECT:
 		if ok := isEncodeMsgpacker(rv.Type()); ok {
 			return rv.Interface().(EncodeMsgpacker).EncodeMsgpack(enl)
 		}
+
+		if isMarshaler(rv.Type()) {
+			return enl.encodeMarshaler(rv)
+		}
+
 		switch rv.Kind() {
 		case reflect.Ptr, reflect.Interface:
 			rv = rv.Elem()
@@ -151,17 +299,100 @@ INDIRECT:
 
 	v = rv.Interface()
 	switch rv.Kind() {
-	case reflect.Slice: // , reflect.Array:
+	case reflect.Slice:
+		return enl.EncodeArray(v)
+	case reflect.Array:
+		// [N]byte is treated as binary data, just like []byte
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return enl.encodeByteArray(rv)
+		}
 		return enl.EncodeArray(v)
 	case reflect.Map:
 		return enl.EncodeMap(v)
 	case reflect.Struct:
 		return enl.EncodeStruct(v)
+	// Named types based on builtin types (type Foo int) are encoded
+	// as their underlying types
+	case reflect.String:
+		return enl.EncodeString(rv.String())
+	case reflect.Bool:
+		return enl.EncodeBool(rv.Bool())
+	case reflect.Int, reflect.Int64:
+		return enl.EncodeInt64(rv.Int())
+	case reflect.Int8:
+		return enl.EncodeInt8(int8(rv.Int()))
+	case reflect.Int16:
+		return enl.EncodeInt16(int16(rv.Int()))
+	case reflect.Int32:
+		return enl.EncodeInt32(int32(rv.Int()))
+	case reflect.Uint, reflect.Uint64:
+		return enl.EncodeUint64(rv.Uint())
+	case reflect.Uint8:
+		return enl.EncodeUint8(uint8(rv.Uint()))
+	case reflect.Uint16:
+		return enl.EncodeUint16(uint16(rv.Uint()))
+	case reflect.Uint32:
+		return enl.EncodeUint32(uint32(rv.Uint()))
+	case reflect.Float32:
+		return enl.EncodeFloat32(float32(rv.Float()))
+	case reflect.Float64:
+		return enl.EncodeFloat64(rv.Float())
 	}
 
 	return errors.Errorf(`msgpack: encode unimplemented for type %s`, rv.Type())
 }
 
+// encodeByteArray encodes a [N]byte as bin
+func (enl *encoderNL) encodeByteArray(rv reflect.Value) error {
+	b := make([]byte, rv.Len())
+	reflect.Copy(reflect.ValueOf(b), rv)
+	return enl.EncodeBytes(b)
+}
+
+// encodeMarshaler encodes values that implement encoding.BinaryMarshaler
+// as bin, and values that implement encoding.TextMarshaler as str.
+// If both are implemented, encoding.BinaryMarshaler is preferred
+func (enl *encoderNL) encodeMarshaler(rv reflect.Value) error {
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return enl.EncodeNil()
+	}
+
+	if m, ok := rv.Interface().(encoding.BinaryMarshaler); ok {
+		b, err := m.MarshalBinary()
+		if err != nil {
+			return errors.Wrapf(err, `msgpack: failed to call MarshalBinary on %s`, rv.Type())
+		}
+		return enl.EncodeBytes(b)
+	}
+
+	b, err := rv.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return errors.Wrapf(err, `msgpack: failed to call MarshalText on %s`, rv.Type())
+	}
+	return enl.EncodeString(string(b))
+}
+
+// encodeRaw writes a pre-encoded msgpack value verbatim. An empty
+// RawMessage is encoded as nil
+func (enl *encoderNL) encodeRaw(v RawMessage) error {
+	if len(v) == 0 {
+		return enl.EncodeNil()
+	}
+
+	if enl.canonical {
+		canonical, err := Canonicalize(v)
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to canonicalize raw message`)
+		}
+		v = canonical
+	}
+
+	if _, err := enl.dst.Write(v); err != nil {
+		return errors.Wrap(err, `msgpack: failed to write raw message`)
+	}
+	return nil
+}
+
 func (enl *encoderNL) encodePositiveFixNum(i uint8) error {
 	return enl.dst.WriteByte(byte(i))
 }
@@ -313,9 +544,21 @@ func (enl *encoderNL) EncodeArray(v interface{}) error {
 		return err
 	}
 
-	switch rv.Type().Elem().Kind() {
+	// The fast paths below only work for slices of builtin element
+	// types. Arrays and named element types (type Foo int) go through
+	// the generic path, and named slice types are converted to their
+	// underlying type first
+	rt := rv.Type()
+	elemKind := rt.Elem().Kind()
+	if rv.Kind() != reflect.Slice || rt.Elem().PkgPath() != "" {
+		elemKind = reflect.Invalid
+	} else if rt.Name() != "" {
+		v = rv.Convert(reflect.SliceOf(rt.Elem())).Interface()
+	}
+
+	switch elemKind {
 	case reflect.String:
-		return enl.encodeArrayString(rv.Convert(reflect.TypeOf([]string{})).Interface())
+		return enl.encodeArrayString(v)
 	case reflect.Bool:
 		return enl.encodeArrayBool(v)
 	case reflect.Int:
@@ -373,8 +616,8 @@ func (enl *encoderNL) EncodeMap(v interface{}) error {
 		return enl.EncodeNil()
 	}
 
-	if rv.Type().Key().Kind() != reflect.String {
-		return errors.Errorf(`msgpack: keys to maps must be strings (not %s)`, rv.Type().Key())
+	if enl.canonical {
+		return enl.encodeMapCanonical(rv)
 	}
 
 	// XXX We do NOT use MapBuilder's convenience methods except for the
@@ -384,8 +627,32 @@ func (enl *encoderNL) EncodeMap(v interface{}) error {
 		return errors.Wrap(err, `msgpack: failed to encode map header`)
 	}
 
-	// These are silly fast paths for common cases
-	switch rv.Type().Elem().Kind() {
+	if rv.Type().Key() != stringType {
+		for _, key := range keys {
+			if err := enl.encodeMapKey(key); err != nil {
+				return errors.Wrap(err, `failed to encode map key`)
+			}
+
+			if err := enl.Encode(rv.MapIndex(key).Interface()); err != nil {
+				return errors.Wrap(err, `failed to encode map value`)
+			}
+		}
+		return nil
+	}
+
+	// These are silly fast paths for common cases. They only work for
+	// the builtin element types, so named types (type Foo int) go
+	// through the generic path
+	elemKind := rv.Type().Elem().Kind()
+	if rv.Type().Elem().PkgPath() != "" {
+		elemKind = reflect.Invalid
+	} else if rv.Type().Name() != "" {
+		// named map types need to be converted to their underlying
+		// type before being passed to the fast paths
+		v = rv.Convert(reflect.MapOf(stringType, rv.Type().Elem())).Interface()
+	}
+
+	switch elemKind {
 	case reflect.String:
 		return enl.encodeMapString(v)
 	case reflect.Bool:
@@ -428,11 +695,77 @@ func (enl *encoderNL) EncodeMap(v interface{}) error {
 	return nil
 }
 
-func parseMsgpackTag(rv reflect.StructField) (string, bool) {
+var stringType = reflect.TypeOf("")
+
+// encodeMapCanonical encodes a map with its elements sorted by the
+// bytes of their encoded keys
+func (enl *encoderNL) encodeMapCanonical(rv reflect.Value) error {
+	keys := rv.MapKeys()
+
+	// Keys and values are encoded into a separate buffer, so that
+	// they can be sorted before being written
+	w := newAppendingWriter(16 * len(keys))
+	elocal := enl.newLocal(w)
+	entries := make([]canonicalEntry, len(keys))
+	for i, key := range keys {
+		entries[i].keyStart = len(w.buf)
+		if err := elocal.encodeMapKey(key); err != nil {
+			return errors.Wrap(err, `failed to encode map key`)
+		}
+		entries[i].keyEnd = len(w.buf)
+		if err := elocal.Encode(rv.MapIndex(key).Interface()); err != nil {
+			return errors.Wrap(err, `failed to encode map value`)
+		}
+		entries[i].valueEnd = len(w.buf)
+	}
+	sortCanonicalEntries(w.buf, entries)
+
+	if err := WriteMapHeader(enl.dst, len(entries)); err != nil {
+		return errors.Wrap(err, `msgpack: failed to encode map header`)
+	}
+	for _, entry := range entries {
+		if _, err := enl.dst.Write(w.buf[entry.keyStart:entry.valueEnd]); err != nil {
+			return errors.Wrap(err, `msgpack: failed to write map element`)
+		}
+	}
+	return nil
+}
+
+// encodeMapKey encodes keys for maps that are not keyed by plain strings
+func (enl *encoderNL) encodeMapKey(rv reflect.Value) error {
+	if hasCustomEncoder(rv.Type()) {
+		return enl.Encode(rv.Interface())
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		return enl.EncodeString(rv.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return enl.EncodeInt64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return enl.EncodeUint64(rv.Uint())
+	}
+	return enl.Encode(rv.Interface())
+}
+
+// tagOptions holds the options that follow the name in a struct tag,
+// e.g. `msgpack:"name,omitempty"`
+type tagOptions struct {
+	// named is true if the tag explicitly specifies the name
+	named     bool
+	omitempty bool
+	omitzero  bool
+	toarray   bool
+	// aliases are the extra names accepted when decoding, specified
+	// as alias=name1|name2
+	aliases []string
+}
+
+func parseMsgpackTag(rv reflect.StructField) (string, tagOptions) {
 	var tags = []string{`msgpack`, `msg`}
 
 	var name = rv.Name
-	var omitempty bool
+	var opts tagOptions
 
 	// We will support both msg and msgpack tags, the former
 	// is used by tinylib/msgp, and the latter vmihailenco/msgpack
@@ -442,29 +775,69 @@ LOOP:
 			l := strings.Split(tag, ",")
 			if len(l) > 0 && l[0] != "" {
 				name = l[0]
+				opts.named = true
 			}
 
-			if len(l) > 1 && l[1] == "omitempty" {
-				omitempty = true
+			for _, opt := range l[1:] {
+				if strings.HasPrefix(opt, "alias=") {
+					opts.aliases = append(opts.aliases, strings.Split(strings.TrimPrefix(opt, "alias="), "|")...)
+					continue
+				}
+
+				switch opt {
+				case "omitempty":
+					opts.omitempty = true
+				case "omitzero":
+					opts.omitzero = true
+				case "toarray":
+					opts.toarray = true
+				}
 			}
 			break LOOP
 		}
 	}
-	return name, omitempty
+	return name, opts
 }
 
-// EncodeTime encodes time.Time as a sequence of two integers
+// EncodeTime encodes time.Time using the timestamp extension (type -1)
+// defined in the msgpack spec. The smallest of the timestamp 32,
+// timestamp 64, and timestamp 96 formats that can represent the
+// value is used.
 func (enl *encoderNL) EncodeTime(t time.Time) error {
-	if err := enl.dst.WriteByte(FixArray0.Byte() + byte(2)); err != nil {
-		return errors.Wrap(err, `msgpack: failed to encode time header`)
-	}
-
-	if err := enl.EncodeInt64(t.Unix()); err != nil {
-		return errors.Wrap(err, `msgpack: failed to encode seconds for time.Time`)
-	}
+	sec := t.Unix()
+	nsec := t.Nanosecond()
 
-	if err := enl.EncodeInt(t.Nanosecond()); err != nil {
-		return errors.Wrap(err, `msgpack: failed to encode nanoseconds for time.Time`)
+	switch {
+	case sec>>32 == 0 && nsec == 0:
+		// timestamp 32: seconds as uint32
+		if err := enl.dst.WriteByteUint8(FixExt4.Byte(), timestampExtByte); err != nil {
+			return errors.Wrap(err, `msgpack: failed to write timestamp 32 header`)
+		}
+		if err := enl.dst.WriteUint32(uint32(sec)); err != nil {
+			return errors.Wrap(err, `msgpack: failed to write seconds for time.Time`)
+		}
+	case sec>>34 == 0:
+		// timestamp 64: 30 bits of nanoseconds, 34 bits of seconds
+		if err := enl.dst.WriteByteUint8(FixExt8.Byte(), timestampExtByte); err != nil {
+			return errors.Wrap(err, `msgpack: failed to write timestamp 64 header`)
+		}
+		if err := enl.dst.WriteUint64(uint64(nsec)<<34 | uint64(sec)); err != nil {
+			return errors.Wrap(err, `msgpack: failed to write seconds and nanoseconds for time.Time`)
+		}
+	default:
+		// timestamp 96: nanoseconds as uint32, seconds as int64
+		if err := enl.dst.WriteByteUint8(Ext8.Byte(), 12); err != nil {
+			return errors.Wrap(err, `msgpack: failed to write timestamp 96 header`)
+		}
+		if err := enl.dst.WriteByte(timestampExtByte); err != nil {
+			return errors.Wrap(err, `msgpack: failed to write timestamp 96 type`)
+		}
+		if err := enl.dst.WriteUint32(uint32(nsec)); err != nil {
+			return errors.Wrap(err, `msgpack: failed to write nanoseconds for time.Time`)
+		}
+		if err := enl.dst.WriteUint64(uint64(sec)); err != nil {
+			return errors.Wrap(err, `msgpack: failed to write seconds for time.Time`)
+		}
 	}
 	return nil
 }
@@ -492,42 +865,101 @@ func (enl *encoderNL) EncodeStruct(v interface{}) error {
 	if rv.Kind() != reflect.Struct {
 		return errors.Errorf(`msgpack: argument to EncodeStruct must be a struct (not %s)`, rv.Type())
 	}
-	mapb := NewMapBuilder()
+	info := getStructInfo(rv.Type())
 
-	rt := rv.Type()
-	for i := 0; i < rt.NumField(); i++ {
-		ft := rt.Field(i)
-		if ft.PkgPath != "" {
-			continue
+	if info.asArray || enl.structAsArray {
+		return enl.encodeStructArray(rv, info)
+	}
+
+	count := len(info.fields)
+	if info.hasOmit || info.hasEmbedded {
+		count = 0
+		for _, field := range info.fields {
+			fv, ok := field.fieldByIndex(rv)
+			if !ok || field.omit(fv) {
+				continue
+			}
+			count++
 		}
+	}
+
+	if err := WriteMapHeader(enl.dst, count); err != nil {
+		return errors.Wrap(err, `msgpack: failed to write map header`)
+	}
 
-		name, omitempty := parseMsgpackTag(ft)
-		if name == "-" {
+	fields := info.fields
+	if enl.canonical {
+		fields = info.sortedFields
+	}
+	for _, field := range fields {
+		// Fields promoted from nil embedded pointers are omitted
+		fv, ok := field.fieldByIndex(rv)
+		if !ok || field.omit(fv) {
 			continue
 		}
 
-		field := rv.Field(i)
-		if omitempty {
-			if reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface()) {
-				continue
-			}
+		if _, err := enl.dst.Write(field.key); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to write key for field %s`, field.name)
 		}
+		if err := field.encode(enl, fv); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to write value for field %s`, field.name)
+		}
+	}
+	return nil
+}
 
-		mapb.Add(name, field.Interface())
+// encodeStructArray encodes a struct as an array of its field values,
+// in the order that they are declared. Since the decoder relies on the
+// position of each value, omitempty and omitzero are ignored
+func (enl *encoderNL) encodeStructArray(rv reflect.Value, info *structInfo) error {
+	if err := enl.EncodeArrayHeader(len(info.fields)); err != nil {
+		return err
 	}
 
-	if err := mapb.Encode(enl.dst); err != nil {
-		return errors.Wrap(err, `msgpack: failed to write map payload`)
+	for _, field := range info.fields {
+		fv, ok := field.fieldByIndex(rv)
+		if !ok {
+			// Fields promoted from nil embedded pointers are encoded
+			// as nil, so that the positions of the fields do not change
+			if err := enl.EncodeNil(); err != nil {
+				return errors.Wrapf(err, `msgpack: failed to write value for field %s`, field.name)
+			}
+			continue
+		}
+		if err := field.encode(enl, fv); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to write value for field %s`, field.name)
+		}
 	}
 	return nil
 }
 
+// isEmptyValue reports whether rv is empty, using the same rules as
+// encoding/json: false, 0, nil pointers and interfaces, and arrays,
+// maps, slices and strings of length zero are empty
+func isEmptyValue(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return rv.IsNil()
+	}
+	return false
+}
+
 func (enl *encoderNL) EncodeExtType(v EncodeMsgpacker) error {
 	t := reflect.TypeOf(v)
 
-	muExtDecode.RLock()
+	muExtEncode.RLock()
 	typ, ok := extEncodeRegistry[t]
-	muExtDecode.RUnlock()
+	muExtEncode.RUnlock()
 
 	if !ok {
 		return errors.Errorf(`msgpack: type %s has not been registered as an extension`, reflect.TypeOf(v))
@@ -541,7 +973,9 @@ func (enl *encoderNL) EncodeExtType(v EncodeMsgpacker) error {
 
 func (enl *encoderNL) EncodeExt(v EncodeMsgpacker) error {
 	w := newAppendingWriter(9)
-	elocal := NewEncoder(w)
+	// The local encoder inherits our settings, so that values inside
+	// of the extension payload are encoded the same way
+	elocal := enl.newLocal(w)
 
 	if err := v.EncodeMsgpack(elocal); err != nil {
 		return errors.Wrapf(err, `msgpack: failed during call to EncodeMsgpack for %s`, reflect.TypeOf(v))
@@ -90,6 +90,24 @@ func (d *encoder) EncodeStruct(v interface{}) error {
 	return d.nl.EncodeStruct(v)
 }
 
+func (d *encoder) Flush() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.nl.Flush()
+}
+
+func (d *encoder) BeginArray() (ArrayWriter, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.nl.BeginArray()
+}
+
+func (d *encoder) BeginMap() (MapWriter, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.nl.BeginMap()
+}
+
 func (d *encoder) EncodeTime(v time.Time) error {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
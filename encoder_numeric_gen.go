@@ -9,6 +9,10 @@ import (
 )
 
 func (enl *encoderNL) EncodeInt(v int) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalInt(enl.scratch[:0], int64(v)))
+	}
+
 	if inNegativeFixNumRange(int64(v)) {
 		return enl.encodeNegativeFixNum(int8(byte(0xff & v)))
 	}
@@ -20,6 +24,10 @@ func (enl *encoderNL) EncodeInt(v int) error {
 }
 
 func (enl *encoderNL) EncodeInt8(v int8) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalInt(enl.scratch[:0], int64(v)))
+	}
+
 	if inNegativeFixNumRange(int64(v)) {
 		return enl.encodeNegativeFixNum(v)
 	}
@@ -31,6 +39,10 @@ func (enl *encoderNL) EncodeInt8(v int8) error {
 }
 
 func (enl *encoderNL) EncodeInt16(v int16) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalInt(enl.scratch[:0], int64(v)))
+	}
+
 	if inNegativeFixNumRange(int64(v)) {
 		return enl.encodeNegativeFixNum(int8(byte(0xff & v)))
 	}
@@ -42,6 +54,10 @@ func (enl *encoderNL) EncodeInt16(v int16) error {
 }
 
 func (enl *encoderNL) EncodeInt32(v int32) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalInt(enl.scratch[:0], int64(v)))
+	}
+
 	if inNegativeFixNumRange(int64(v)) {
 		return enl.encodeNegativeFixNum(int8(byte(0xff & v)))
 	}
@@ -53,6 +69,10 @@ func (enl *encoderNL) EncodeInt32(v int32) error {
 }
 
 func (enl *encoderNL) EncodeInt64(v int64) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalInt(enl.scratch[:0], int64(v)))
+	}
+
 	if inNegativeFixNumRange(int64(v)) {
 		return enl.encodeNegativeFixNum(int8(byte(0xff & v)))
 	}
@@ -64,6 +84,10 @@ func (enl *encoderNL) EncodeInt64(v int64) error {
 }
 
 func (enl *encoderNL) EncodeUint(v uint) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalUint(enl.scratch[:0], uint64(v)))
+	}
+
 	if inPositiveFixNumRange(int64(v)) {
 		return enl.encodePositiveFixNum(uint8(0xff & v))
 	}
@@ -75,6 +99,10 @@ func (enl *encoderNL) EncodeUint(v uint) error {
 }
 
 func (enl *encoderNL) EncodeUint8(v uint8) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalUint(enl.scratch[:0], uint64(v)))
+	}
+
 	if inPositiveFixNumRange(int64(v)) {
 		return enl.encodePositiveFixNum(uint8(0xff & v))
 	}
@@ -86,6 +114,10 @@ func (enl *encoderNL) EncodeUint8(v uint8) error {
 }
 
 func (enl *encoderNL) EncodeUint16(v uint16) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalUint(enl.scratch[:0], uint64(v)))
+	}
+
 	if inPositiveFixNumRange(int64(v)) {
 		return enl.encodePositiveFixNum(uint8(0xff & v))
 	}
@@ -97,6 +129,10 @@ func (enl *encoderNL) EncodeUint16(v uint16) error {
 }
 
 func (enl *encoderNL) EncodeUint32(v uint32) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalUint(enl.scratch[:0], uint64(v)))
+	}
+
 	if inPositiveFixNumRange(int64(v)) {
 		return enl.encodePositiveFixNum(uint8(0xff & v))
 	}
@@ -108,6 +144,10 @@ func (enl *encoderNL) EncodeUint32(v uint32) error {
 }
 
 func (enl *encoderNL) EncodeUint64(v uint64) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalUint(enl.scratch[:0], uint64(v)))
+	}
+
 	if inPositiveFixNumRange(int64(v)) {
 		return enl.encodePositiveFixNum(uint8(0xff & v))
 	}
@@ -119,6 +159,10 @@ func (enl *encoderNL) EncodeUint64(v uint64) error {
 }
 
 func (enl *encoderNL) EncodeFloat32(f float32) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalFloat(enl.scratch[:0], float64(f)))
+	}
+
 	if err := enl.dst.WriteByteUint32(Float.Byte(), math.Float32bits(f)); err != nil {
 		return errors.Wrap(err, `msgpack: failed to write Float`)
 	}
@@ -126,6 +170,10 @@ func (enl *encoderNL) EncodeFloat32(f float32) error {
 }
 
 func (enl *encoderNL) EncodeFloat64(f float64) error {
+	if enl.canonical {
+		return enl.writeCanonical(appendCanonicalFloat(enl.scratch[:0], float64(f)))
+	}
+
 	if err := enl.dst.WriteByteUint64(Double.Byte(), math.Float64bits(f)); err != nil {
 		return errors.Wrap(err, `msgpack: failed to write Double`)
 	}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"math"
 	"testing"
+	"time"
 
 	msgpack "github.com/lestrrat-go/msgpack"
 	"github.com/stretchr/testify/assert"
@@ -891,6 +892,75 @@ func TestEncodeStruct(t *testing.T) {
 	}
 }
 
+type zeroer struct {
+	Value int
+}
+
+func (z zeroer) IsZero() bool {
+	return z.Value < 0
+}
+
+type omitStruct struct {
+	Slice   []int                  `msgpack:"slice,omitempty"`
+	Map     map[string]interface{} `msgpack:"map,omitempty"`
+	Ptr     *int                   `msgpack:"ptr,omitempty"`
+	Float   float64                `msgpack:"float,omitempty"`
+	Bool    bool                   `msgpack:"bool,omitempty"`
+	Struct  dummyStruct            `msgpack:"struct,omitempty"`
+	Time    time.Time              `msgpack:"time,omitzero"`
+	Zeroer  zeroer                 `msgpack:"zeroer,omitzero"`
+	Array   [2]int                 `msgpack:"array,omitzero"`
+	Present string                 `msgpack:"present"`
+}
+
+func TestEncodeStructOmit(t *testing.T) {
+	t.Parallel()
+
+	decode := func(t *testing.T, v interface{}) map[string]interface{} {
+		b, err := msgpack.Marshal(v)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return nil
+		}
+		var m map[string]interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &m), "Unmarshal should succeed") {
+			return nil
+		}
+		return m
+	}
+
+	t.Run("empty values are omitted", func(t *testing.T) {
+		t.Parallel()
+		m := decode(t, omitStruct{
+			Slice:  []int{},
+			Map:    map[string]interface{}{},
+			Zeroer: zeroer{Value: -1},
+		})
+		assert.Equal(t, map[string]interface{}{
+			"struct":  map[string]interface{}{"Message": ""},
+			"present": "",
+		}, m, "empty values should be omitted")
+	})
+	t.Run("non-empty values are kept", func(t *testing.T) {
+		t.Parallel()
+		var zero int
+		ts := time.Unix(1234567890, 0).UTC()
+		m := decode(t, omitStruct{
+			Slice:  []int{1},
+			Map:    map[string]interface{}{"foo": "bar"},
+			Ptr:    &zero,
+			Float:  0.5,
+			Bool:   true,
+			Time:   ts,
+			Zeroer: zeroer{Value: 0},
+			Array:  [2]int{0, 1},
+		})
+		assert.Len(t, m, 10, "all fields should be present")
+		if assert.IsType(t, time.Time{}, m["time"], "time should be present") {
+			assert.True(t, ts.Equal(m["time"].(time.Time)), "time should match")
+		}
+	})
+}
+
 func TestEncodeArray(t *testing.T) {
 	t.Parallel()
 	t.Run("encode []bool", func(t *testing.T) {
@@ -920,3 +990,135 @@ func TestEncodeArray(t *testing.T) {
 		}
 	})
 }
+
+func TestEncodeTime(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		Name     string
+		Value    time.Time
+		Expected []byte
+	}{
+		{
+			Name:     "timestamp 32",
+			Value:    time.Unix(1234567890, 0),
+			Expected: []byte{msgpack.FixExt4.Byte(), 0xff, 0x49, 0x96, 0x02, 0xd2},
+		},
+		{
+			Name:     "timestamp 64",
+			Value:    time.Unix(1234567890, 123),
+			Expected: []byte{msgpack.FixExt8.Byte(), 0xff, 0x00, 0x00, 0x01, 0xec, 0x49, 0x96, 0x02, 0xd2},
+		},
+		{
+			Name:     "timestamp 96",
+			Value:    time.Unix(-1, 123),
+			Expected: []byte{msgpack.Ext8.Byte(), 12, 0xff, 0x00, 0x00, 0x00, 0x7b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			b, err := msgpack.Marshal(tc.Value)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+			if !assert.Equal(t, tc.Expected, b, "Output should match") {
+				return
+			}
+		})
+	}
+}
+
+// countingWriter counts the number of calls to Write. It is not
+// recognized as a buffered writer by the Encoder
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestEncoderBuffering(t *testing.T) {
+	t.Parallel()
+
+	// Not a map, so that the output is deterministic
+	v := struct {
+		Foo []interface{}
+		Bar bool
+	}{Foo: []interface{}{1, "two", 3.0}, Bar: true}
+	expected, err := msgpack.Marshal(v)
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+
+	t.Run("buffered", func(t *testing.T) {
+		t.Parallel()
+		var w countingWriter
+		enc := msgpack.NewEncoder(&w)
+		if !assert.NoError(t, enc.Encode(v), "Encode should succeed") {
+			return
+		}
+		assert.Zero(t, w.writes, "nothing should be written before Flush")
+		if !assert.NoError(t, enc.Flush(), "Flush should succeed") {
+			return
+		}
+		assert.Equal(t, 1, w.writes, "Flush should write once")
+		assert.Equal(t, expected, w.Bytes(), "output should match")
+	})
+	t.Run("small buffer", func(t *testing.T) {
+		t.Parallel()
+		var w countingWriter
+		enc := msgpack.NewEncoder(&w, msgpack.WithBufferSize(16))
+		if !assert.NoError(t, enc.Encode(v), "Encode should succeed") {
+			return
+		}
+		if !assert.NoError(t, enc.Flush(), "Flush should succeed") {
+			return
+		}
+		assert.True(t, w.writes > 1, "output larger than the buffer should take multiple writes")
+		assert.Equal(t, expected, w.Bytes(), "output should match")
+	})
+	t.Run("unbuffered", func(t *testing.T) {
+		t.Parallel()
+		var w countingWriter
+		enc := msgpack.NewEncoder(&w, msgpack.WithBufferSize(0))
+		if !assert.NoError(t, enc.Encode(v), "Encode should succeed") {
+			return
+		}
+		assert.True(t, w.writes > 1, "each write should be forwarded")
+		assert.Equal(t, expected, w.Bytes(), "output should match")
+	})
+	t.Run("already buffered", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		if !assert.NoError(t, msgpack.NewEncoder(&buf).Encode(v), "Encode should succeed") {
+			return
+		}
+		assert.Equal(t, expected, buf.Bytes(), "*bytes.Buffer should be written to directly")
+	})
+	t.Run("SetDestination", func(t *testing.T) {
+		t.Parallel()
+		var w1, w2 countingWriter
+		enc := msgpack.NewEncoderNoLock(&w1)
+		if !assert.NoError(t, enc.Encode(v), "Encode should succeed") {
+			return
+		}
+		if !assert.NoError(t, enc.Flush(), "Flush should succeed") {
+			return
+		}
+		enc.SetDestination(&w2)
+		if !assert.NoError(t, enc.Encode(v), "Encode should succeed") {
+			return
+		}
+		if !assert.NoError(t, enc.Flush(), "Flush should succeed") {
+			return
+		}
+		assert.Equal(t, expected, w1.Bytes(), "output should match")
+		assert.Equal(t, expected, w2.Bytes(), "output should match")
+	})
+}
@@ -1,6 +1,9 @@
 package msgpack
 
-import "reflect"
+import (
+	"reflect"
+	"strconv"
+)
 
 func (e *InvalidDecodeError) Error() string {
 	if e.Type == nil {
@@ -12,3 +15,7 @@ func (e *InvalidDecodeError) Error() string {
 	}
 	return "msgpack: Decode(nil " + e.Type.String() + ")"
 }
+
+func (e *UnknownFieldError) Error() string {
+	return "msgpack: unknown field " + strconv.Quote(e.Key) + " for " + e.Type.String()
+}
@@ -1,21 +1,41 @@
 package msgpack
 
 import (
+	"math"
 	"reflect"
 	"sync"
 
 	"github.com/pkg/errors"
 )
 
+// timestampExtType is the extension type reserved by the msgpack
+// spec for timestamps. timestampExtByte is the same value as it
+// appears on the wire
+const timestampExtType = -1
+const timestampExtByte = byte(0xff)
+
 var muExtDecode sync.RWMutex
 var muExtEncode sync.RWMutex
-var extDecodeRegistry = make(map[int]reflect.Type)
-var extEncodeRegistry = make(map[reflect.Type]int)
+var extDecodeRegistry = make(map[int8]reflect.Type)
+var extDecodeTypes = make(map[reflect.Type]int8) // reverse of extDecodeRegistry
+var extEncodeRegistry = make(map[reflect.Type]int8)
 
 var decodeMsgpackerType = reflect.TypeOf((*DecodeMsgpacker)(nil)).Elem()
 var encodeMsgpackerType = reflect.TypeOf((*EncodeMsgpacker)(nil)).Elem()
 
+// RegisterExt registers the type of v as the extension type typ.
+// Extension types are signed 8-bit integers, so typ must be in the
+// range of -128 to 127. Type -1 is reserved for timestamps, which are
+// handled natively, and cannot be registered.
 func RegisterExt(typ int, v interface{}) error {
+	if typ < math.MinInt8 || typ > math.MaxInt8 {
+		return errors.Errorf(`msgpack: extension type %d out of range (-128 <= x <= 127)`, typ)
+	}
+
+	if typ == timestampExtType {
+		return errors.Errorf(`msgpack: extension type %d is reserved for timestamps`, typ)
+	}
+
 	rt := reflect.TypeOf(v)
 
 	var decodeType = rt
@@ -39,12 +59,31 @@ func RegisterExt(typ int, v interface{}) error {
 	}
 
 	muExtDecode.Lock()
-	extDecodeRegistry[typ] = decodeType
+	prev, replaced := extDecodeRegistry[int8(typ)]
+	extDecodeRegistry[int8(typ)] = decodeType
+	extDecodeTypes[decodeType] = int8(typ)
+	if replaced && prev != decodeType && extDecodeTypes[prev] == int8(typ) {
+		// prev may still be registered under another extension type
+		delete(extDecodeTypes, prev)
+		for otherTyp, t := range extDecodeRegistry {
+			if t == prev {
+				extDecodeTypes[prev] = otherTyp
+				break
+			}
+		}
+	}
 	muExtDecode.Unlock()
 
 	muExtEncode.Lock()
-	extEncodeRegistry[encodeType] = typ
+	extEncodeRegistry[encodeType] = int8(typ)
 	muExtEncode.Unlock()
 
 	return nil
 }
+
+func isExtDecodeType(t reflect.Type) bool {
+	muExtDecode.RLock()
+	defer muExtDecode.RUnlock()
+	_, ok := extDecodeTypes[t]
+	return ok
+}
@@ -0,0 +1,79 @@
+package msgpack_test
+
+import (
+	"testing"
+
+	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type negativeExt struct {
+	Value uint16
+}
+
+func init() {
+	if err := msgpack.RegisterExt(-128, negativeExt{}); err != nil {
+		panic(err)
+	}
+}
+
+func (v *negativeExt) DecodeMsgpack(d msgpack.Decoder) error {
+	x, err := d.Reader().ReadUint16()
+	if err != nil {
+		return errors.Wrap(err, `failed to read uint16`)
+	}
+	v.Value = x
+	return nil
+}
+
+func (v negativeExt) EncodeMsgpack(e msgpack.Encoder) error {
+	return e.Writer().WriteUint16(v.Value)
+}
+
+func TestRegisterExt(t *testing.T) {
+	t.Parallel()
+
+	t.Run("out of range", func(t *testing.T) {
+		t.Parallel()
+		for _, typ := range []int{-129, 128} {
+			if !assert.Error(t, msgpack.RegisterExt(typ, negativeExt{}), "RegisterExt(%d) should fail", typ) {
+				return
+			}
+		}
+	})
+	t.Run("reserved for timestamp", func(t *testing.T) {
+		t.Parallel()
+		if !assert.Error(t, msgpack.RegisterExt(-1, negativeExt{}), "RegisterExt(-1) should fail") {
+			return
+		}
+	})
+	t.Run("negative type id", func(t *testing.T) {
+		t.Parallel()
+		v := negativeExt{Value: 0xcafe}
+		b, err := msgpack.Marshal(v)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, []byte{msgpack.FixExt2.Byte(), 0x80, 0xca, 0xfe}, b, "Output should match") {
+			return
+		}
+
+		var r negativeExt
+		if !assert.NoError(t, msgpack.Unmarshal(b, &r), "Unmarshal should succeed") {
+			return
+		}
+		if !assert.Equal(t, v, r, "RoundTrip should succeed") {
+			return
+		}
+
+		var i interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &i), "Unmarshal should succeed") {
+			return
+		}
+		if !assert.Equal(t, &v, i, "RoundTrip should succeed") {
+			return
+		}
+	})
+}
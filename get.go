@@ -0,0 +1,176 @@
+package msgpack
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is the cause of the error returned by Get and GetInto
+// when the path does not match any value
+var ErrNotFound = errors.New(`msgpack: value not found`)
+
+// Get extracts a single nested value from the msgpack payload data,
+// without decoding the rest of the payload. Each element of path is
+// either a string, which selects the value associated with a str key
+// in a map, or an integer, which selects an element of an array by
+// its index, or the value associated with an integer key in a map.
+//
+// Values that are not on the path are skipped using their length
+// prefixes, and are never materialized. The returned RawMessage
+// aliases data.
+//
+// If the path does not match any value, the cause of the returned
+// error (see errors.Cause) is ErrNotFound
+func Get(data []byte, path ...interface{}) (RawMessage, error) {
+	dnl := newDecoderNL([]Option{
+		WithMaxLength(len(data)),
+		WithMaxElements(len(data)),
+		WithZeroCopy(true),
+	})
+	dnl.setSourceBytes(data)
+
+	for i, p := range path {
+		if err := dnl.seek(p); err != nil {
+			return nil, errors.Wrapf(err, `msgpack: failed to get path element %d (%v)`, i, p)
+		}
+	}
+
+	var raw RawMessage
+	if err := dnl.decodeRaw(&raw); err != nil {
+		return nil, errors.Wrap(err, `msgpack: failed to read value`)
+	}
+	return raw, nil
+}
+
+// GetInto extracts a single nested value from the msgpack payload
+// data like Get, and decodes it into v
+func GetInto(data []byte, v interface{}, path ...interface{}) error {
+	raw, err := Get(data, path...)
+	if err != nil {
+		return err
+	}
+	return Unmarshal(raw, v)
+}
+
+// seek positions the decoder at the value selected by the path
+// element p, within the array or map that starts at the current
+// position
+func (dnl *decoderNL) seek(p interface{}) error {
+	key, isInt := pathInteger(p)
+	if _, isString := p.(string); !isInt && !isString {
+		return errors.Errorf(`msgpack: invalid path element type %T`, p)
+	}
+
+	code, err := dnl.PeekCode()
+	if err != nil {
+		return errors.Wrap(err, `msgpack: failed to read code`)
+	}
+
+	switch {
+	case IsArrayFamily(code):
+		var l int
+		if err := dnl.DecodeArrayLength(&l); err != nil {
+			return errors.Wrap(err, `msgpack: failed to decode array length`)
+		}
+		if !isInt || key.neg || key.u >= uint64(l) {
+			return ErrNotFound
+		}
+		for i := 0; i < int(key.u); i++ {
+			if err := dnl.Skip(); err != nil {
+				return errors.Wrapf(err, `msgpack: failed to skip array element %d`, i)
+			}
+		}
+		return nil
+	case IsMapFamily(code):
+		var l int
+		if err := dnl.DecodeMapLength(&l); err != nil {
+			return errors.Wrap(err, `msgpack: failed to decode map length`)
+		}
+		for i := 0; i < l; i++ {
+			ok, err := dnl.matchKey(p, key, isInt)
+			if err != nil {
+				return errors.Wrapf(err, `msgpack: failed to read map key at index %d`, i)
+			}
+			if ok {
+				return nil
+			}
+			if err := dnl.Skip(); err != nil {
+				return errors.Wrapf(err, `msgpack: failed to skip map value at index %d`, i)
+			}
+		}
+		return ErrNotFound
+	}
+	return ErrNotFound
+}
+
+// matchKey consumes the next map key, and reports whether it matches
+// the path element p
+func (dnl *decoderNL) matchKey(p interface{}, key integerKey, isInt bool) (bool, error) {
+	code, err := dnl.PeekCode()
+	if err != nil {
+		return false, errors.Wrap(err, `msgpack: failed to read code`)
+	}
+
+	// Only str and integer keys can match
+	if !IsStrFamily(code) && (!IsNumberFamily(code) || code == Float || code == Double) {
+		return false, dnl.Skip()
+	}
+
+	tok, err := dnl.Token()
+	if err != nil {
+		return false, err
+	}
+	switch tok.Kind {
+	case TokenString:
+		s, ok := p.(string)
+		return ok && s == tok.String, nil
+	case TokenInt:
+		if tok.Int < 0 {
+			return isInt && key.neg && key.i == tok.Int, nil
+		}
+		return isInt && !key.neg && key.u == uint64(tok.Int), nil
+	case TokenUint:
+		return isInt && !key.neg && key.u == tok.Uint, nil
+	}
+	return false, nil
+}
+
+// integerKey is an integer path element. Negative values are stored
+// in i, and non-negative values in u
+type integerKey struct {
+	neg bool
+	i   int64
+	u   uint64
+}
+
+func pathInteger(p interface{}) (integerKey, bool) {
+	var i int64
+	switch p := p.(type) {
+	case int:
+		i = int64(p)
+	case int8:
+		i = int64(p)
+	case int16:
+		i = int64(p)
+	case int32:
+		i = int64(p)
+	case int64:
+		i = p
+	case uint:
+		return integerKey{u: uint64(p)}, true
+	case uint8:
+		return integerKey{u: uint64(p)}, true
+	case uint16:
+		return integerKey{u: uint64(p)}, true
+	case uint32:
+		return integerKey{u: uint64(p)}, true
+	case uint64:
+		return integerKey{u: p}, true
+	default:
+		return integerKey{}, false
+	}
+
+	if i < 0 {
+		return integerKey{neg: true, i: i}, true
+	}
+	return integerKey{u: uint64(i)}, true
+}
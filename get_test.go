@@ -0,0 +1,127 @@
+package msgpack_test
+
+import (
+	"testing"
+
+	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	var data []byte
+	data = msgpack.AppendMapHeader(data, 3)
+	data = msgpack.AppendString(data, "meta")
+	data = msgpack.AppendArrayHeader(data, 2)
+	data = msgpack.AppendBytes(data, []byte("skipped"))
+	data = msgpack.AppendNil(data)
+	data = msgpack.AppendString(data, "record")
+	data = msgpack.AppendMapHeader(data, 3)
+	data = msgpack.AppendFloat64(data, 1.5)
+	data = msgpack.AppendString(data, "float key")
+	data = msgpack.AppendString(data, "user")
+	data = msgpack.AppendMapHeader(data, 2)
+	data = msgpack.AppendString(data, "name")
+	data = msgpack.AppendString(data, "alice")
+	data = msgpack.AppendString(data, "id")
+	data = msgpack.AppendUint32(data, 1234)
+	data = msgpack.AppendString(data, "tags")
+	data = msgpack.AppendArrayHeader(data, 3)
+	data = msgpack.AppendString(data, "a")
+	data = msgpack.AppendString(data, "b")
+	data = msgpack.AppendString(data, "c")
+	data = msgpack.AppendString(data, "codes")
+	data = msgpack.AppendMapHeader(data, 2)
+	data = msgpack.AppendInt8(data, -1)
+	data = msgpack.AppendString(data, "minus one")
+	data = msgpack.AppendUint16(data, 404)
+	data = msgpack.AppendString(data, "not found")
+
+	var tags []byte
+	tags = msgpack.AppendArrayHeader(tags, 3)
+	tags = msgpack.AppendString(tags, "a")
+	tags = msgpack.AppendString(tags, "b")
+	tags = msgpack.AppendString(tags, "c")
+
+	t.Run("found", func(t *testing.T) {
+		t.Parallel()
+		testcases := []struct {
+			Name     string
+			Path     []interface{}
+			Expected []byte
+		}{
+			{Name: "nested key", Path: []interface{}{"record", "user", "id"}, Expected: msgpack.AppendUint32(nil, 1234)},
+			{Name: "array index", Path: []interface{}{"record", "tags", 2}, Expected: msgpack.AppendString(nil, "c")},
+			{Name: "unsigned array index", Path: []interface{}{"record", "tags", uint8(1)}, Expected: msgpack.AppendString(nil, "b")},
+			{Name: "negative integer key", Path: []interface{}{"codes", int64(-1)}, Expected: msgpack.AppendString(nil, "minus one")},
+			{Name: "integer key", Path: []interface{}{"codes", 404}, Expected: msgpack.AppendString(nil, "not found")},
+			{Name: "container", Path: []interface{}{"record", "tags"}, Expected: tags},
+			{Name: "empty path", Path: nil, Expected: data},
+		}
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				t.Parallel()
+				raw, err := msgpack.Get(data, tc.Path...)
+				if !assert.NoError(t, err, "Get should succeed") {
+					return
+				}
+				assert.Equal(t, tc.Expected, []byte(raw), "value should match")
+			})
+		}
+	})
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+		paths := [][]interface{}{
+			{"missing"},
+			{"record", "user", "email"},
+			{"record", "tags", 3},
+			{"record", "tags", -1},
+			{"record", "tags", "a"},
+			{"record", "user", "id", "nested"},
+			{"meta", 1, "nil"},
+			{"codes", 1},
+		}
+		for _, path := range paths {
+			_, err := msgpack.Get(data, path...)
+			assert.Equal(t, msgpack.ErrNotFound, errors.Cause(err), "Get(%v) should fail with ErrNotFound", path)
+		}
+	})
+	t.Run("GetInto", func(t *testing.T) {
+		t.Parallel()
+		var user struct {
+			Name string `msgpack:"name"`
+			ID   uint32 `msgpack:"id"`
+		}
+		if !assert.NoError(t, msgpack.GetInto(data, &user, "record", "user"), "GetInto should succeed") {
+			return
+		}
+		assert.Equal(t, "alice", user.Name, "Name should match")
+		assert.Equal(t, uint32(1234), user.ID, "ID should match")
+
+		var tag string
+		if !assert.NoError(t, msgpack.GetInto(data, &tag, "record", "tags", 0), "GetInto should succeed") {
+			return
+		}
+		assert.Equal(t, "a", tag, "tag should match")
+
+		err := msgpack.GetInto(data, &tag, "record", "nope")
+		assert.Equal(t, msgpack.ErrNotFound, errors.Cause(err), "GetInto should fail with ErrNotFound")
+	})
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+		for _, p := range []interface{}{1.5, struct{}{}, nil} {
+			_, err := msgpack.Get(data, "record", p)
+			if assert.Error(t, err, "invalid path element type %T should fail", p) {
+				assert.NotEqual(t, msgpack.ErrNotFound, errors.Cause(err), "error should not be ErrNotFound")
+			}
+		}
+
+		_, err := msgpack.Get(data[:len(data)-5], "codes", 404)
+		if assert.Error(t, err, "truncated input should fail") {
+			assert.NotEqual(t, msgpack.ErrNotFound, errors.Cause(err), "error should not be ErrNotFound")
+		}
+	})
+}
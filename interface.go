@@ -120,6 +120,14 @@ type InvalidDecodeError struct {
 	Type reflect.Type
 }
 
+// UnknownFieldError is returned when the Decoder has been configured
+// to disallow unknown fields, and a map key that does not correspond
+// to any field in the destination struct is encountered
+type UnknownFieldError struct {
+	Key  string
+	Type reflect.Type
+}
+
 // EncodeMsgpacker is an interface for those objects that provide
 // their own serialization. The objects are responsible for providing
 // the complete msgpack payload, including the code, payload length
@@ -163,6 +171,33 @@ type MapBuilder interface {
 	Reset()
 }
 
+// ArrayWriter writes the elements of an array that was started with
+// Encoder.BeginArray. Close must be called once all of the elements
+// have been added. While a nested array or map is open, elements can
+// not be added until it has been closed
+type ArrayWriter interface {
+	Add(interface{}) error
+	BeginArray() (ArrayWriter, error)
+	BeginMap() (MapWriter, error)
+	Close() error
+	Count() int
+}
+
+// MapWriter writes the elements of a map that was started with
+// Encoder.BeginMap. Close must be called once all of the elements
+// have been added. While a nested array or map is open, elements can
+// not be added until it has been closed.
+//
+// The elements are written in the order that they are added, even
+// if the Encoder is in canonical mode
+type MapWriter interface {
+	Add(key, value interface{}) error
+	BeginArray(key interface{}) (ArrayWriter, error)
+	BeginMap(key interface{}) (MapWriter, error)
+	Close() error
+	Count() int
+}
+
 // Writer handles low-level writing to an io.Writer.
 // Note that Writers are NEVER meant to be shared concurrently
 // between goroutines. You DO NOT write serialized data concurrently
@@ -230,6 +265,16 @@ type Encoder interface {
 	EncodeTime(time.Time) error
 	Writer() Writer
 
+	// Flush writes any data buffered by the Encoder to the destination
+	Flush() error
+
+	// BeginArray starts an array whose elements are added one by
+	// one, without knowing the number of elements in advance
+	BeginArray() (ArrayWriter, error)
+	// BeginMap starts a map whose elements are added one by one,
+	// without knowing the number of elements in advance
+	BeginMap() (MapWriter, error)
+
 	// SetDestination is a utility tool that allows the user to swap out the
 	// reader object the Encoder is writing to, there by saving the
 	// extra cost of re-instantiaion.
@@ -243,6 +288,20 @@ type encoder struct {
 
 type encoderNL struct {
 	dst Writer
+	// buf is non-nil if the output is being buffered
+	buf           *bufio.Writer
+	bufferSize    int
+	canonical     bool
+	structAsArray bool
+	// scratch is used to prepare small values before writing them
+	scratch [9]byte
+
+	// patcher is set if the destination can be used to patch headers
+	// of streaming arrays and maps
+	patcher        patchableWriter
+	spillThreshold int
+	// stream is the innermost streaming array or map that is open
+	stream *streamWriter
 }
 
 // Decoder reads serialized data from a source pointed to by
@@ -282,12 +341,81 @@ type Decoder interface {
 	ReadCode() (Code, error)
 	Reader() Reader
 
+	// Skip discards the next value, including all of its elements if
+	// it is an array or a map, without materializing it.
+	Skip() error
+
+	// Token returns the next token in the stream. At the end of the
+	// stream, Token returns io.EOF. See Token for details
+	Token() (Token, error)
+
+	// InputOffset returns the number of bytes of the source that have
+	// been consumed so far
+	InputOffset() int64
+
+	// ArrayIterator reads the header of an array, and returns an
+	// iterator that decodes its elements one at a time
+	ArrayIterator() ArrayIterator
+	// MapIterator reads the header of a map, and returns an iterator
+	// that decodes its elements one at a time
+	MapIterator() MapIterator
+
 	// SetSource is a utility tool that allows the user to swap out the
 	// reader object the Decoder is reading from, there by saving the
 	// extra cost of re-instantiaion.
 	SetSource(io.Reader)
 }
 
+// ArrayIterator decodes the elements of an array one at a time, so
+// that arbitrarily large arrays can be decoded in constant memory.
+//
+//	it := dec.ArrayIterator()
+//	for it.Next() {
+//		var v T
+//		if err := it.Decode(&v); err != nil {
+//			...
+//		}
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Elements that are not decoded are skipped by the next call to Next.
+// If the iteration is stopped early, Close skips the rest of the
+// elements, so that the Decoder is positioned after the array
+type ArrayIterator interface {
+	// Next advances to the next element, and returns false when
+	// there are no more elements or an error occurred
+	Next() bool
+	// Decode decodes the current element into v
+	Decode(v interface{}) error
+	// Len returns the number of elements in the array
+	Len() int
+	// Err returns the first error that occurred during iteration
+	Err() error
+	// Close skips the remaining elements
+	Close() error
+}
+
+// MapIterator decodes the elements of a map one at a time, in the
+// same way as ArrayIterator. Keys and values that are not decoded
+// are skipped
+type MapIterator interface {
+	// Next advances to the next key/value pair, and returns false when
+	// there are no more elements or an error occurred
+	Next() bool
+	// DecodeKey decodes the key of the current element into v
+	DecodeKey(v interface{}) error
+	// DecodeValue decodes the value of the current element into v
+	DecodeValue(v interface{}) error
+	// Len returns the number of key/value pairs in the map
+	Len() int
+	// Err returns the first error that occurred during iteration
+	Err() error
+	// Close skips the remaining elements
+	Close() error
+}
+
 type decoder struct {
 	nl *decoderNL
 	mu sync.RWMutex
@@ -299,6 +427,27 @@ type decoder struct {
 // locking version will force other method calls to wait while
 // an operation is progressing on the object.
 type decoderNL struct {
-	raw *bufio.Reader
+	raw rawReader
 	src Reader
+	// slice is non-nil if the decoder reads from a byte slice
+	slice *sliceReader
+	// counter is non-nil if the decoder reads from an io.Reader
+	counter *countingReader
+
+	mapKeyMode            MapKeyMode
+	mapType               MapType
+	binAsString           bool
+	disallowUnknownFields bool
+	caseInsensitiveFields bool
+	useNumber             bool
+	normalizeIntegers     bool
+	zeroCopy              bool
+
+	// limits for untrusted input. zero means unlimited
+	maxLength     int
+	maxElements   int
+	maxDepth      int
+	maxTotalBytes int64
+	// depth is the current nesting depth, tracked when maxDepth is set
+	depth int
 }
@@ -221,6 +221,26 @@ func generateLockingWrappers() error {
 			name: "Reader",
 			rets: []string{"Reader"},
 		},
+		{
+			name: "Skip",
+			rets: []string{"error"},
+		},
+		{
+			name: "Token",
+			rets: []string{"Token", "error"},
+		},
+		{
+			name: "InputOffset",
+			rets: []string{"int64"},
+		},
+		{
+			name: "ArrayIterator",
+			rets: []string{"ArrayIterator"},
+		},
+		{
+			name: "MapIterator",
+			rets: []string{"MapIterator"},
+		},
 	}
 
 	for _, w := range wrappers {
@@ -299,23 +319,53 @@ func generateIntegerTypes(dst io.Writer) error {
 		fmt.Fprintf(dst, "\nreturn errors.Wrap(err, `msgpack: failed to read code for %s`)", data.Code)
 		fmt.Fprintf(dst, "\n}")
 		fmt.Fprintf(dst, "\nif IsFixNumFamily(Code(code)) {")
-		fmt.Fprintf(dst, "\n*v = %s(code)", typ)
-		fmt.Fprintf(dst, "\nreturn nil")
-		fmt.Fprintf(dst, "\n}")
-		// We need to allow numbers with lower bit sizes that fit in this type
-		var baseName string
 		if data.Unsigned {
-			baseName = "Uint"
+			fmt.Fprintf(dst, "\nif int8(code) < 0 {")
+			fmt.Fprintf(dst, "\nreturn errors.Errorf(`msgpack: value %%d out of range for %s`, int8(code))", typ)
+			fmt.Fprintf(dst, "\n}")
+			fmt.Fprintf(dst, "\n*v = %s(code)", typ)
 		} else {
-			baseName = "Int"
+			fmt.Fprintf(dst, "\n*v = %s(int8(code))", typ)
 		}
+		fmt.Fprintf(dst, "\nreturn nil")
+		fmt.Fprintf(dst, "\n}")
+		// We need to allow numbers with lower bit sizes that fit in this
+		// type. Numbers of the other signedness are allowed as long as
+		// their values fit, since canonical encoding always writes
+		// non-negative integers using the unsigned family
 		fmt.Fprintf(dst, "\nswitch Code(code) {")
 		for size := data.Bits; size >= 8; size /= 2 {
-			fmt.Fprintf(dst, "\ncase %s%d:", baseName, size)
+			fmt.Fprintf(dst, "\ncase Int%d:", size)
 			fmt.Fprintf(dst, "\nx, err := dnl.src.ReadUint%d()", size)
 			fmt.Fprintf(dst, "\nif err != nil {")
 			fmt.Fprintf(dst, "\nreturn errors.Wrap(err, `msgpack: failed to read payload for %s`)", typ)
 			fmt.Fprintf(dst, "\n}")
+			if data.Unsigned {
+				fmt.Fprintf(dst, "\nif int%d(x) < 0 {", size)
+				fmt.Fprintf(dst, "\nreturn errors.Errorf(`msgpack: value %%d out of range for %s`, int%d(x))", typ, size)
+				fmt.Fprintf(dst, "\n}")
+				fmt.Fprintf(dst, "\n*v = %s(x)", typ)
+			} else if size == data.Bits {
+				fmt.Fprintf(dst, "\n*v = %s(x)", typ)
+			} else {
+				fmt.Fprintf(dst, "\n*v = %s(int%d(x))", typ, size)
+			}
+			fmt.Fprintf(dst, "\nreturn nil")
+		}
+		for size := 64; size >= 8; size /= 2 {
+			if data.Unsigned && size > data.Bits {
+				continue
+			}
+			fmt.Fprintf(dst, "\ncase Uint%d:", size)
+			fmt.Fprintf(dst, "\nx, err := dnl.src.ReadUint%d()", size)
+			fmt.Fprintf(dst, "\nif err != nil {")
+			fmt.Fprintf(dst, "\nreturn errors.Wrap(err, `msgpack: failed to read payload for %s`)", typ)
+			fmt.Fprintf(dst, "\n}")
+			if !data.Unsigned && size >= data.Bits {
+				fmt.Fprintf(dst, "\nif x > math.MaxInt%d {", data.Bits)
+				fmt.Fprintf(dst, "\nreturn errors.Errorf(`msgpack: value %%d out of range for %s`, x)", typ)
+				fmt.Fprintf(dst, "\n}")
+			}
 			fmt.Fprintf(dst, "\n*v = %s(x)", typ)
 			fmt.Fprintf(dst, "\nreturn nil")
 		}
@@ -340,6 +390,33 @@ func generateFloatTypes(dst io.Writer) error {
 		data := types[typ]
 
 		fmt.Fprintf(dst, "\n\nfunc (dnl *decoderNL) Decode%s(v *%s) error {", util.Ucfirst(typ.String()), typ)
+		if typ == reflect.Float64 {
+			// float32 values can be widened without losing precision,
+			// so they are accepted as well
+			fmt.Fprintf(dst, "\ncode, err := dnl.src.ReadUint8()")
+			fmt.Fprintf(dst, "\nif err != nil {")
+			fmt.Fprintf(dst, "\nreturn errors.Wrap(err, `msgpack: failed to read %s`)", typ)
+			fmt.Fprintf(dst, "\n}")
+			fmt.Fprintf(dst, "\n\nswitch code {")
+			fmt.Fprintf(dst, "\ncase Double.Byte():")
+			fmt.Fprintf(dst, "\nx, err := dnl.src.ReadUint64()")
+			fmt.Fprintf(dst, "\nif err != nil {")
+			fmt.Fprintf(dst, "\nreturn errors.Wrap(err, `msgpack: failed to read %s`)", typ)
+			fmt.Fprintf(dst, "\n}")
+			fmt.Fprintf(dst, "\n*v = math.Float64frombits(x)")
+			fmt.Fprintf(dst, "\ncase Float.Byte():")
+			fmt.Fprintf(dst, "\nx, err := dnl.src.ReadUint32()")
+			fmt.Fprintf(dst, "\nif err != nil {")
+			fmt.Fprintf(dst, "\nreturn errors.Wrap(err, `msgpack: failed to read %s`)", typ)
+			fmt.Fprintf(dst, "\n}")
+			fmt.Fprintf(dst, "\n*v = float64(math.Float32frombits(x))")
+			fmt.Fprintf(dst, "\ndefault:")
+			fmt.Fprintf(dst, "\nreturn errors.Errorf(`msgpack: expected %s, got %%s`, Code(code))", data.Code)
+			fmt.Fprintf(dst, "\n}")
+			fmt.Fprintf(dst, "\nreturn nil")
+			fmt.Fprintf(dst, "\n}")
+			continue
+		}
 		fmt.Fprintf(dst, "\ncode, x, err := dnl.src.ReadByteUint%d()", data.Bits)
 		fmt.Fprintf(dst, "\nif err != nil {")
 		fmt.Fprintf(dst, "\nreturn errors.Wrap(err, `msgpack: failed to read %s`)", typ)
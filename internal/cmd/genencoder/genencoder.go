@@ -52,6 +52,9 @@ func _main() error {
 	if err := generateNumericEncoders(); err != nil {
 		return errors.Wrap(err, `failed to generate numeric encoders`)
 	}
+	if err := generateNumericAppenders(); err != nil {
+		return errors.Wrap(err, `failed to generate numeric appenders`)
+	}
 	if err := generateLockingWrappers(); err != nil {
 		return errors.Wrap(err, `failed to generate locking wrappers`)
 	}
@@ -206,6 +209,18 @@ func generateLockingWrappers() error {
 			},
 			rets: []string{"error"},
 		},
+		{
+			name: "Flush",
+			rets: []string{"error"},
+		},
+		{
+			name: "BeginArray",
+			rets: []string{"ArrayWriter", "error"},
+		},
+		{
+			name: "BeginMap",
+			rets: []string{"MapWriter", "error"},
+		},
 		{
 			name: "EncodeTime",
 			args: []argument{
@@ -290,6 +305,14 @@ func generateIntegerTypes(dst io.Writer) error {
 	for _, typ := range keys {
 		data := types[typ]
 		fmt.Fprintf(dst, "\n\nfunc (enl *encoderNL) Encode%s(v %s) error {", util.Ucfirst(typ.String()), typ)
+		fmt.Fprintf(dst, "\nif enl.canonical {")
+		switch typ {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			fmt.Fprintf(dst, "\nreturn enl.writeCanonical(appendCanonicalUint(enl.scratch[:0], uint64(v)))")
+		default:
+			fmt.Fprintf(dst, "\nreturn enl.writeCanonical(appendCanonicalInt(enl.scratch[:0], int64(v)))")
+		}
+		fmt.Fprintf(dst, "\n}\n")
 		switch typ {
 		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 			fmt.Fprintf(dst, "\nif inPositiveFixNumRange(int64(v)) {")
@@ -333,6 +356,9 @@ func generateFloatTypes(dst io.Writer) error {
 	for _, typ := range keys {
 		data := types[typ]
 		fmt.Fprintf(dst, "\n\nfunc (enl *encoderNL) EncodeFloat%d(f float%d) error {", data.Bits, data.Bits)
+		fmt.Fprintf(dst, "\nif enl.canonical {")
+		fmt.Fprintf(dst, "\nreturn enl.writeCanonical(appendCanonicalFloat(enl.scratch[:0], float64(f)))")
+		fmt.Fprintf(dst, "\n}\n")
 		fmt.Fprintf(dst, "\nif err := enl.dst.WriteByteUint%d(%s.Byte(), math.Float%dbits(f)); err != nil {", data.Bits, data.Code, data.Bits)
 		fmt.Fprintf(dst, "\nreturn errors.Wrap(err, `msgpack: failed to write %s`)", data.Code)
 		fmt.Fprintf(dst, "\n}")
@@ -341,3 +367,65 @@ func generateFloatTypes(dst io.Writer) error {
 	}
 	return nil
 }
+
+func generateNumericAppenders() error {
+	var buf bytes.Buffer
+
+	buf.WriteString("package msgpack")
+	buf.WriteString("\n\n// Auto-generated by internal/cmd/genencoder/genencoder.go. DO NOT EDIT!")
+	buf.WriteString("\n\nimport (")
+	buf.WriteString("\n\"math\"")
+	buf.WriteString("\n)")
+
+	keys := make([]reflect.Kind, 0, len(integerTypes))
+	for k := range integerTypes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return uint(keys[i]) < uint(keys[j])
+	})
+	for _, typ := range keys {
+		data := integerTypes[typ]
+		name := util.Ucfirst(typ.String())
+		fmt.Fprintf(&buf, "\n\n// Append%s appends the msgpack representation of v to dst,", name)
+		fmt.Fprintf(&buf, "\n// using the same format as Encoder.Encode%s", name)
+		fmt.Fprintf(&buf, "\nfunc Append%s(dst []byte, v %s) []byte {", name, typ)
+		switch typ {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			fmt.Fprintf(&buf, "\nif inPositiveFixNumRange(int64(v)) {")
+		default:
+			fmt.Fprintf(&buf, "\nif inNegativeFixNumRange(int64(v)) {")
+		}
+		fmt.Fprintf(&buf, "\nreturn append(dst, byte(v))")
+		fmt.Fprintf(&buf, "\n}")
+		fmt.Fprintf(&buf, "\nreturn appendByteUint%d(dst, %s.Byte(), ", data.Bits, data.Code)
+		switch typ {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			fmt.Fprintf(&buf, "v")
+		default:
+			fmt.Fprintf(&buf, "uint%d(v)", data.Bits)
+		}
+		fmt.Fprintf(&buf, ")")
+		fmt.Fprintf(&buf, "\n}")
+	}
+
+	keys = keys[:0]
+	for k := range floatTypes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return uint(keys[i]) < uint(keys[j])
+	})
+	for _, typ := range keys {
+		data := floatTypes[typ]
+		fmt.Fprintf(&buf, "\n\n// AppendFloat%d appends the msgpack representation of f to dst", data.Bits)
+		fmt.Fprintf(&buf, "\nfunc AppendFloat%d(dst []byte, f float%d) []byte {", data.Bits, data.Bits)
+		fmt.Fprintf(&buf, "\nreturn appendByteUint%d(dst, %s.Byte(), math.Float%dbits(f))", data.Bits, data.Code, data.Bits)
+		fmt.Fprintf(&buf, "\n}")
+	}
+
+	if err := util.WriteFormattedFile("append_numeric_gen.go", buf.Bytes()); err != nil {
+		return errors.Wrap(err, `failed to write to file`)
+	}
+	return nil
+}
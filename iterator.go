@@ -0,0 +1,204 @@
+package msgpack
+
+import (
+	"github.com/pkg/errors"
+)
+
+// arrayIterator decodes the elements of an array one by one
+type arrayIterator struct {
+	dnl    *decoderNL
+	length int
+	index  int
+	// pending is true if the current element has not been consumed
+	pending bool
+	err     error
+}
+
+// mapIterator decodes the elements of a map one by one
+type mapIterator struct {
+	dnl    *decoderNL
+	length int
+	index  int
+	// keyPending and valuePending are true if the key and the value of
+	// the current element have not been consumed
+	keyPending   bool
+	valuePending bool
+	err          error
+}
+
+// ArrayIterator reads the header of the next array in the stream, and
+// returns an iterator over its elements. A nil value is treated as an
+// empty array. Errors are reported by the Err method of the iterator
+func (dnl *decoderNL) ArrayIterator() ArrayIterator {
+	it := &arrayIterator{dnl: dnl}
+	if isNil, err := dnl.consumeNil(); err != nil || isNil {
+		it.err = err
+		return it
+	}
+	if err := dnl.DecodeArrayLength(&it.length); err != nil {
+		it.err = errors.Wrap(err, `msgpack: failed to decode array length`)
+	}
+	return it
+}
+
+// MapIterator reads the header of the next map in the stream, and
+// returns an iterator over its elements. A nil value is treated as an
+// empty map. Errors are reported by the Err method of the iterator
+func (dnl *decoderNL) MapIterator() MapIterator {
+	it := &mapIterator{dnl: dnl}
+	if err := dnl.DecodeMapLength(&it.length); err != nil {
+		it.err = errors.Wrap(err, `msgpack: failed to decode map length`)
+	}
+	if it.length < 0 {
+		it.length = 0
+	}
+	return it
+}
+
+// consumeNil consumes the next value if it is nil
+func (dnl *decoderNL) consumeNil() (bool, error) {
+	code, err := dnl.PeekCode()
+	if err != nil {
+		return false, err
+	}
+	if code != Nil {
+		return false, nil
+	}
+	_, err = dnl.ReadCode()
+	return true, err
+}
+
+func (it *arrayIterator) Len() int {
+	return it.length
+}
+
+func (it *arrayIterator) Err() error {
+	return it.err
+}
+
+func (it *arrayIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.pending {
+		if err := it.dnl.Skip(); err != nil {
+			it.err = errors.Wrapf(err, `msgpack: failed to skip array element %d`, it.index-1)
+			return false
+		}
+		it.pending = false
+	}
+	if it.index >= it.length {
+		return false
+	}
+	it.index++
+	it.pending = true
+	return true
+}
+
+func (it *arrayIterator) Decode(v interface{}) error {
+	if it.err != nil {
+		return it.err
+	}
+	if !it.pending {
+		return errors.New(`msgpack: Decode called without a successful call to Next`)
+	}
+	it.pending = false
+	if err := it.dnl.Decode(v); err != nil {
+		it.err = errors.Wrapf(err, `msgpack: failed to decode array element %d`, it.index-1)
+		return it.err
+	}
+	return nil
+}
+
+func (it *arrayIterator) Close() error {
+	for it.Next() {
+	}
+	return it.err
+}
+
+func (it *mapIterator) Len() int {
+	return it.length
+}
+
+func (it *mapIterator) Err() error {
+	return it.err
+}
+
+func (it *mapIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if err := it.skipPending(); err != nil {
+		return false
+	}
+	if it.index >= it.length {
+		return false
+	}
+	it.index++
+	it.keyPending = true
+	it.valuePending = true
+	return true
+}
+
+// skipPending skips the parts of the current element that have not
+// been consumed
+func (it *mapIterator) skipPending() error {
+	if it.keyPending {
+		it.keyPending = false
+		if err := it.dnl.Skip(); err != nil {
+			it.err = errors.Wrapf(err, `msgpack: failed to skip map key at index %d`, it.index-1)
+			return it.err
+		}
+	}
+	if it.valuePending {
+		it.valuePending = false
+		if err := it.dnl.Skip(); err != nil {
+			it.err = errors.Wrapf(err, `msgpack: failed to skip map value at index %d`, it.index-1)
+			return it.err
+		}
+	}
+	return nil
+}
+
+func (it *mapIterator) DecodeKey(v interface{}) error {
+	if it.err != nil {
+		return it.err
+	}
+	if !it.keyPending {
+		return errors.New(`msgpack: DecodeKey called without a successful call to Next`)
+	}
+	it.keyPending = false
+	if err := it.dnl.Decode(v); err != nil {
+		it.err = errors.Wrapf(err, `msgpack: failed to decode map key at index %d`, it.index-1)
+		return it.err
+	}
+	return nil
+}
+
+func (it *mapIterator) DecodeValue(v interface{}) error {
+	if it.err != nil {
+		return it.err
+	}
+	if !it.valuePending {
+		return errors.New(`msgpack: DecodeValue called without a successful call to Next`)
+	}
+	if it.keyPending {
+		it.keyPending = false
+		if err := it.dnl.Skip(); err != nil {
+			it.err = errors.Wrapf(err, `msgpack: failed to skip map key at index %d`, it.index-1)
+			return it.err
+		}
+	}
+	it.valuePending = false
+	if err := it.dnl.Decode(v); err != nil {
+		it.err = errors.Wrapf(err, `msgpack: failed to decode map value at index %d`, it.index-1)
+		return it.err
+	}
+	return nil
+}
+
+func (it *mapIterator) Close() error {
+	for it.Next() {
+	}
+	return it.err
+}
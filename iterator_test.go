@@ -0,0 +1,172 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"testing"
+
+	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoderIterators(t *testing.T) {
+	t.Parallel()
+
+	var b []byte
+	b = msgpack.AppendArrayHeader(b, 4)
+	b = msgpack.AppendInt8(b, 1)
+	b = msgpack.AppendString(b, "two")
+	b = msgpack.AppendArrayHeader(b, 2)
+	b = msgpack.AppendInt8(b, 3)
+	b = msgpack.AppendInt8(b, 4)
+	b = msgpack.AppendInt8(b, 5)
+	b = msgpack.AppendMapHeader(b, 3)
+	b = msgpack.AppendString(b, "a")
+	b = msgpack.AppendInt8(b, 1)
+	b = msgpack.AppendString(b, "b")
+	b = msgpack.AppendArrayHeader(b, 1)
+	b = msgpack.AppendString(b, "skipped")
+	b = msgpack.AppendString(b, "c")
+	b = msgpack.AppendInt8(b, 3)
+	b = msgpack.AppendString(b, "trailer")
+
+	newDecoders := map[string]func() msgpack.Decoder{
+		"io.Reader":  func() msgpack.Decoder { return msgpack.NewDecoder(bytes.NewReader(b)) },
+		"byte slice": func() msgpack.Decoder { return msgpack.NewDecoderBytes(b) },
+	}
+	for name, newDecoder := range newDecoders {
+		newDecoder := newDecoder
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			t.Run("full iteration", func(t *testing.T) {
+				t.Parallel()
+				dec := newDecoder()
+
+				it := dec.ArrayIterator()
+				assert.Equal(t, 4, it.Len(), "Len should match")
+				var values []interface{}
+				for it.Next() {
+					var v interface{}
+					if !assert.NoError(t, it.Decode(&v), "Decode should succeed") {
+						return
+					}
+					values = append(values, v)
+				}
+				if !assert.NoError(t, it.Err(), "Err should be nil") {
+					return
+				}
+				assert.Equal(t, []interface{}{int8(1), "two", []interface{}{int8(3), int8(4)}, int8(5)}, values, "values should match")
+
+				mit := dec.MapIterator()
+				assert.Equal(t, 3, mit.Len(), "Len should match")
+				var keys []string
+				var ints []int
+				for mit.Next() {
+					var key string
+					if !assert.NoError(t, mit.DecodeKey(&key), "DecodeKey should succeed") {
+						return
+					}
+					keys = append(keys, key)
+					if key == "b" {
+						// leave the value for Next to skip
+						continue
+					}
+					var v int
+					if !assert.NoError(t, mit.DecodeValue(&v), "DecodeValue should succeed") {
+						return
+					}
+					ints = append(ints, v)
+				}
+				if !assert.NoError(t, mit.Err(), "Err should be nil") {
+					return
+				}
+				assert.Equal(t, []string{"a", "b", "c"}, keys, "keys should match")
+				assert.Equal(t, []int{1, 3}, ints, "values should match")
+
+				var trailer string
+				if !assert.NoError(t, dec.Decode(&trailer), "Decode should succeed") {
+					return
+				}
+				assert.Equal(t, "trailer", trailer, "decoder should be positioned after the map")
+			})
+			t.Run("partial iteration", func(t *testing.T) {
+				t.Parallel()
+				dec := newDecoder()
+
+				it := dec.ArrayIterator()
+				// The first element is never decoded
+				if !assert.True(t, it.Next(), "Next should succeed") {
+					return
+				}
+				if !assert.True(t, it.Next(), "Next should succeed") {
+					return
+				}
+				var s string
+				if !assert.NoError(t, it.Decode(&s), "Decode should succeed") {
+					return
+				}
+				assert.Equal(t, "two", s, "element should match")
+				assert.Error(t, it.Decode(&s), "Decode without Next should fail")
+				if !assert.NoError(t, it.Close(), "Close should succeed") {
+					return
+				}
+
+				mit := dec.MapIterator()
+				if !assert.True(t, mit.Next(), "Next should succeed") {
+					return
+				}
+				// DecodeValue skips the key
+				var v int
+				if !assert.NoError(t, mit.DecodeValue(&v), "DecodeValue should succeed") {
+					return
+				}
+				assert.Equal(t, 1, v, "value should match")
+				if !assert.NoError(t, mit.Close(), "Close should succeed") {
+					return
+				}
+
+				var trailer string
+				if !assert.NoError(t, dec.Decode(&trailer), "Decode should succeed") {
+					return
+				}
+				assert.Equal(t, "trailer", trailer, "decoder should be positioned after the map")
+			})
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		t.Parallel()
+		dec := msgpack.NewDecoderBytes([]byte{msgpack.Nil.Byte(), msgpack.Nil.Byte()})
+
+		it := dec.ArrayIterator()
+		assert.False(t, it.Next(), "Next should return false")
+		assert.NoError(t, it.Err(), "Err should be nil")
+
+		mit := dec.MapIterator()
+		assert.False(t, mit.Next(), "Next should return false")
+		assert.NoError(t, mit.Err(), "Err should be nil")
+	})
+	t.Run("not an array", func(t *testing.T) {
+		t.Parallel()
+		it := msgpack.NewDecoderBytes(msgpack.AppendString(nil, "foo")).ArrayIterator()
+		assert.False(t, it.Next(), "Next should return false")
+		assert.Error(t, it.Err(), "Err should be non-nil")
+	})
+	t.Run("truncated", func(t *testing.T) {
+		t.Parallel()
+		data := msgpack.AppendArrayHeader(nil, 3)
+		data = msgpack.AppendInt8(data, 1)
+
+		it := msgpack.NewDecoderBytes(data).ArrayIterator()
+		var values []int
+		for it.Next() {
+			var v int
+			if err := it.Decode(&v); err != nil {
+				break
+			}
+			values = append(values, v)
+		}
+		assert.Equal(t, []int{1}, values, "only the first element should be decoded")
+		assert.Error(t, it.Err(), "Err should be non-nil")
+		assert.False(t, it.Next(), "Next should return false after an error")
+	})
+}
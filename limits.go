@@ -0,0 +1,109 @@
+package msgpack
+
+import (
+	"io"
+	"strconv"
+)
+
+// DefaultMaxDepth is the maximum nesting depth used by Unmarshal,
+// unless overridden with WithMaxDepth
+const DefaultMaxDepth = 10000
+
+// LimitKind identifies the limit that was exceeded in a LimitError
+type LimitKind int
+
+const (
+	// LimitLength is the maximum length of bin, str and ext payloads
+	LimitLength LimitKind = iota + 1
+	// LimitElements is the maximum number of elements in an array or a map
+	LimitElements
+	// LimitDepth is the maximum nesting depth of values
+	LimitDepth
+	// LimitTotalBytes is the maximum number of bytes read from the source
+	LimitTotalBytes
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case LimitLength:
+		return "length"
+	case LimitElements:
+		return "element count"
+	case LimitDepth:
+		return "nesting depth"
+	case LimitTotalBytes:
+		return "total bytes"
+	}
+	return "LimitKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// LimitError is returned when the input exceeds one of the limits
+// configured on the Decoder
+type LimitError struct {
+	Kind  LimitKind
+	Limit int64
+	// Value is the offending value, e.g. the length prefix that was
+	// read. It is not available for LimitTotalBytes
+	Value int64
+}
+
+func (e *LimitError) Error() string {
+	if e.Kind == LimitTotalBytes {
+		return "msgpack: input exceeds limit of " + strconv.FormatInt(e.Limit, 10) + " bytes"
+	}
+	return "msgpack: " + e.Kind.String() + " " + strconv.FormatInt(e.Value, 10) + " exceeds limit of " + strconv.FormatInt(e.Limit, 10)
+}
+
+// checkLength makes sure that a bin, str or ext payload length does
+// not exceed the configured limit
+func (dnl *decoderNL) checkLength(l int64) error {
+	if dnl.maxLength > 0 && l > int64(dnl.maxLength) {
+		return &LimitError{Kind: LimitLength, Limit: int64(dnl.maxLength), Value: l}
+	}
+	return nil
+}
+
+// checkElements makes sure that an array or map element count does
+// not exceed the configured limit
+func (dnl *decoderNL) checkElements(n int) error {
+	if dnl.maxElements > 0 && n > dnl.maxElements {
+		return &LimitError{Kind: LimitElements, Limit: int64(dnl.maxElements), Value: int64(n)}
+	}
+	return nil
+}
+
+// limitReader is like io.LimitedReader, but returns a LimitError
+// instead of io.EOF once the limit has been reached, if the source
+// has more data to offer
+type limitReader struct {
+	src       io.Reader
+	limit     int64
+	remaining int64
+	exceeded  bool
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if !r.exceeded {
+			// Input that ends exactly at the limit is fine, so we
+			// need to check whether there actually is more data
+			var probe [1]byte
+			n, err := r.src.Read(probe[:])
+			if n == 0 {
+				return 0, err
+			}
+			r.exceeded = true
+		}
+		return 0, &LimitError{Kind: LimitTotalBytes, Limit: r.limit}
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.src.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,136 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertLimitError(t *testing.T, err error, kind msgpack.LimitKind) bool {
+	if !assert.Error(t, err, "decoding should fail") {
+		return false
+	}
+
+	le, ok := errors.Cause(err).(*msgpack.LimitError)
+	if !assert.True(t, ok, "error should be a LimitError (got %s)", err) {
+		return false
+	}
+	return assert.Equal(t, kind, le.Kind, "limit kind should match")
+}
+
+func TestDecoderLimits(t *testing.T) {
+	t.Parallel()
+
+	t.Run("malicious lengths in Unmarshal", func(t *testing.T) {
+		t.Parallel()
+		testcases := []struct {
+			Name string
+			Data []byte
+			Kind msgpack.LimitKind
+		}{
+			{Name: "Bin32", Data: []byte{msgpack.Bin32.Byte(), 0xff, 0xff, 0xff, 0xff}, Kind: msgpack.LimitLength},
+			{Name: "Str32", Data: []byte{msgpack.Str32.Byte(), 0xff, 0xff, 0xff, 0xff}, Kind: msgpack.LimitLength},
+			{Name: "Ext32", Data: []byte{msgpack.Ext32.Byte(), 0xff, 0xff, 0xff, 0xff, 0x1}, Kind: msgpack.LimitLength},
+			{Name: "Array32", Data: []byte{msgpack.Array32.Byte(), 0xff, 0xff, 0xff, 0xff}, Kind: msgpack.LimitElements},
+			{Name: "Map32", Data: []byte{msgpack.Map32.Byte(), 0xff, 0xff, 0xff, 0xff}, Kind: msgpack.LimitElements},
+		}
+
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				t.Parallel()
+				var v interface{}
+				assertLimitError(t, msgpack.Unmarshal(tc.Data, &v), tc.Kind)
+
+				var raw msgpack.RawMessage
+				assertLimitError(t, msgpack.Unmarshal(tc.Data, &raw), tc.Kind)
+			})
+		}
+	})
+	t.Run("nesting depth", func(t *testing.T) {
+		t.Parallel()
+		// 100,000 nested arrays
+		data := bytes.Repeat([]byte{msgpack.FixArray1.Byte()}, 100000)
+		data = append(data, msgpack.Nil.Byte())
+
+		var v interface{}
+		err := msgpack.Unmarshal(data, &v)
+		if assertLimitError(t, err, msgpack.LimitDepth) {
+			assert.True(t, len(err.Error()) < 1000, "error message should not grow with the nesting depth (got %d bytes)", len(err.Error()))
+		}
+
+		var list []interface{}
+		err = msgpack.Unmarshal(data[len(data)-4:], &list, msgpack.WithMaxDepth(3))
+		assertLimitError(t, err, msgpack.LimitDepth)
+		err = msgpack.Unmarshal(data[len(data)-4:], &list, msgpack.WithMaxDepth(4))
+		assert.NoError(t, err, "decoding within the limit should succeed")
+	})
+	t.Run("options", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(map[string]interface{}{
+			"foo": strings.Repeat("x", 100),
+			"bar": []int{1, 2, 3},
+		})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var v map[string]interface{}
+		assertLimitError(t, msgpack.Unmarshal(b, &v, msgpack.WithMaxLength(99)), msgpack.LimitLength)
+		assertLimitError(t, msgpack.Unmarshal(b, &v, msgpack.WithMaxElements(1)), msgpack.LimitElements)
+		assertLimitError(t, msgpack.Unmarshal(b, &v, msgpack.WithMaxDepth(2)), msgpack.LimitDepth)
+		assertLimitError(t, msgpack.Unmarshal(b, &v, msgpack.WithMaxTotalBytes(int64(len(b)-1))), msgpack.LimitTotalBytes)
+
+		options := []msgpack.Option{
+			msgpack.WithMaxLength(100),
+			msgpack.WithMaxElements(3),
+			msgpack.WithMaxDepth(3),
+			msgpack.WithMaxTotalBytes(int64(len(b))),
+		}
+		assert.NoError(t, msgpack.Unmarshal(b, &v, options...), "decoding within the limits should succeed")
+	})
+	t.Run("total bytes on a stream", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		for i := 0; i < 3; i++ {
+			if !assert.NoError(t, enc.EncodeString("Hello"), "EncodeString should succeed") {
+				return
+			}
+		}
+
+		dec := msgpack.NewDecoder(&buf, msgpack.WithMaxTotalBytes(12))
+		var s string
+		for i := 0; i < 2; i++ {
+			if !assert.NoError(t, dec.DecodeString(&s), "DecodeString should succeed") {
+				return
+			}
+		}
+		assertLimitError(t, dec.DecodeString(&s), msgpack.LimitTotalBytes)
+	})
+	t.Run("stream ending exactly at the total bytes limit", func(t *testing.T) {
+		t.Parallel()
+		var data []byte
+		for i := 0; i < 2; i++ {
+			b, err := msgpack.Marshal("Hello")
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+			data = append(data, b...)
+		}
+
+		dec := msgpack.NewDecoder(bytes.NewReader(data), msgpack.WithMaxTotalBytes(int64(len(data))))
+		var v interface{}
+		for i := 0; i < 2; i++ {
+			if !assert.NoError(t, dec.Decode(&v), "Decode should succeed") {
+				return
+			}
+		}
+		assert.Equal(t, io.EOF, errors.Cause(dec.Decode(&v)), "Decode should return io.EOF at the end of the stream")
+	})
+}
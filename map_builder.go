@@ -40,14 +40,14 @@ func WriteMapHeader(dst io.Writer, c int) error {
 		if err := w.WriteByte(FixMap0.Byte() + byte(c)); err != nil {
 			return errors.Wrap(err, `failed to write element size prefix`)
 		}
-	case c < math.MaxUint16:
+	case c <= math.MaxUint16:
 		if err := w.WriteByte(Map16.Byte()); err != nil {
 			return errors.Wrap(err, `failed to write 16-bit element size prefix`)
 		}
 		if err := w.WriteUint16(uint16(c)); err != nil {
 			return errors.Wrap(err, `failed to write 16-bit element size`)
 		}
-	case c < math.MaxUint32:
+	case c <= math.MaxUint32:
 		if err := w.WriteByte(Map32.Byte()); err != nil {
 			return errors.Wrap(err, `failed to write 32-bit element size prefix`)
 		}
@@ -75,6 +75,9 @@ func (b *mapBuilder) Encode(dst io.Writer) error {
 			return errors.Wrapf(err, `map builder: failed to encode map element for %s`, b.buffer[i])
 		}
 	}
+	if err := e.Flush(); err != nil {
+		return errors.Wrap(err, `map builder: failed to flush map elements`)
+	}
 	return nil
 }
 
@@ -6,7 +6,6 @@
 package msgpack
 
 import (
-	"bytes"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -30,12 +29,19 @@ var encoderPool = sync.Pool{
 }
 
 // Marshal takes a Go value and serializes it in msgpack format.
-func Marshal(v interface{}) ([]byte, error) {
+// The options are passed to the underlying Encoder.
+func Marshal(v interface{}, options ...Option) ([]byte, error) {
 	var buf = appendingWriterPool.Get().(*appendingWriter)
 	defer releaseAppendingWriter(buf)
 
-	var enc = encoderPool.Get().(Encoder)
-	enc.SetDestination(buf)
+	// Pooled encoders are only used with the default settings
+	var enc Encoder
+	if len(options) > 0 {
+		enc = NewEncoderNoLock(buf, options...)
+	} else {
+		enc = encoderPool.Get().(Encoder)
+		enc.SetDestination(buf)
+	}
 	if err := enc.Encode(v); err != nil {
 		return nil, errors.Wrap(err, `failed to marshal`)
 	}
@@ -45,11 +51,48 @@ func Marshal(v interface{}) ([]byte, error) {
 	return ret, nil
 }
 
+// MarshalAppend is like Marshal, but appends the serialized value to
+// dst and returns the extended buffer. If an error occurs, dst is
+// returned with its original length.
+func MarshalAppend(dst []byte, v interface{}, options ...Option) ([]byte, error) {
+	var buf = appendingWriterPool.Get().(*appendingWriter)
+	defer releaseAppendingWriter(buf)
+
+	// Swap in dst, making sure that the pooled writer's own buffer is
+	// restored (and dst is not retained) when we are done
+	pooled := buf.buf
+	buf.buf = dst
+	defer func() { buf.buf = pooled }()
+
+	var enc Encoder
+	if len(options) > 0 {
+		enc = NewEncoderNoLock(buf, options...)
+	} else {
+		enc = encoderPool.Get().(Encoder)
+		enc.SetDestination(buf)
+	}
+	if err := enc.Encode(v); err != nil {
+		return dst, errors.Wrap(err, `failed to marshal`)
+	}
+	return buf.Bytes(), nil
+}
+
 // Unmarshal takes a byte slice and a pointer to a Go value and
 // deserializes the Go value from the data in msgpack format.
-func Unmarshal(data []byte, v interface{}) error {
-	buf := bytes.NewBuffer(data)
-	if err := NewDecoder(buf).Decode(v); err != nil {
+// The options are passed to the underlying Decoder.
+//
+// Since data may come from an untrusted source, limits are applied
+// by default: lengths and element counts may not exceed len(data),
+// and the nesting depth may not exceed DefaultMaxDepth. These can be
+// overridden using the options.
+func Unmarshal(data []byte, v interface{}, options ...Option) error {
+	limits := []Option{
+		WithMaxLength(len(data)),
+		WithMaxElements(len(data)),
+		WithMaxDepth(DefaultMaxDepth),
+	}
+
+	if err := NewDecoderBytesNoLock(data, append(limits, options...)...).Decode(v); err != nil {
 		return errors.Wrap(err, `failed to unmarshal`)
 	}
 	return nil
@@ -0,0 +1,267 @@
+package msgpack
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Number represents a msgpack integer or floating point number. It
+// remembers the code that it was encoded with, so that the value can
+// be inspected without having to type switch over all of the numeric
+// Go types, and re-encoded without changing its representation.
+//
+// The zero value represents the integer 0.
+type Number struct {
+	code Code
+	// bits holds the payload as read from the wire: int64 values
+	// stored as uint64 for signed integers and fixnums, the value
+	// itself for unsigned integers, and the IEEE 754 bits for floats
+	bits uint64
+}
+
+// IsNumberFamily returns true if the given code is equivalent to
+// one of the integer or floating point number codes
+func IsNumberFamily(c Code) bool {
+	if IsFixNumFamily(c) {
+		return true
+	}
+
+	switch c {
+	case Int8, Int16, Int32, Int64, Uint8, Uint16, Uint32, Uint64, Float, Double:
+		return true
+	}
+	return false
+}
+
+// Code returns the msgpack code that the number is encoded with
+func (n Number) Code() Code {
+	return n.code
+}
+
+// IsFloat returns true if the number is a floating point number
+func (n Number) IsFloat() bool {
+	return n.code == Float || n.code == Double
+}
+
+func (n Number) isUnsigned() bool {
+	switch n.code {
+	case Uint8, Uint16, Uint32, Uint64:
+		return true
+	}
+	return false
+}
+
+func (n Number) float() float64 {
+	if n.code == Float {
+		return float64(math.Float32frombits(uint32(n.bits)))
+	}
+	return math.Float64frombits(n.bits)
+}
+
+// Int64 returns the number as an int64. An error is returned if the
+// value does not fit in an int64, or if it is a floating point number
+// with a fractional part
+func (n Number) Int64() (int64, error) {
+	switch {
+	case n.IsFloat():
+		f := n.float()
+		// -2^63 is exactly representable, 2^63 is out of range
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, errors.Errorf(`msgpack: %s cannot be represented as int64`, n)
+		}
+		return int64(f), nil
+	case n.isUnsigned():
+		if n.bits > math.MaxInt64 {
+			return 0, errors.Errorf(`msgpack: %s overflows int64`, n)
+		}
+		return int64(n.bits), nil
+	default:
+		return int64(n.bits), nil
+	}
+}
+
+// Uint64 returns the number as a uint64. An error is returned if the
+// value is negative, or if it is a floating point number that does not
+// fit in a uint64 or that has a fractional part
+func (n Number) Uint64() (uint64, error) {
+	switch {
+	case n.IsFloat():
+		f := n.float()
+		if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return 0, errors.Errorf(`msgpack: %s cannot be represented as uint64`, n)
+		}
+		return uint64(f), nil
+	case n.isUnsigned():
+		return n.bits, nil
+	default:
+		if int64(n.bits) < 0 {
+			return 0, errors.Errorf(`msgpack: %s cannot be represented as uint64`, n)
+		}
+		return n.bits, nil
+	}
+}
+
+// Float64 returns the number as a float64. An error is returned if the
+// number is an integer that cannot be represented exactly as a float64
+func (n Number) Float64() (float64, error) {
+	switch {
+	case n.IsFloat():
+		return n.float(), nil
+	case n.isUnsigned():
+		f := float64(n.bits)
+		if f >= math.MaxUint64 || uint64(f) != n.bits {
+			return 0, errors.Errorf(`msgpack: %s cannot be represented exactly as float64`, n)
+		}
+		return f, nil
+	default:
+		f := float64(int64(n.bits))
+		if f >= math.MaxInt64 || int64(f) != int64(n.bits) {
+			return 0, errors.Errorf(`msgpack: %s cannot be represented exactly as float64`, n)
+		}
+		return f, nil
+	}
+}
+
+// String returns the decimal representation of the number
+func (n Number) String() string {
+	switch {
+	case n.code == Float:
+		return strconv.FormatFloat(n.float(), 'g', -1, 32)
+	case n.code == Double:
+		return strconv.FormatFloat(n.float(), 'g', -1, 64)
+	case n.isUnsigned():
+		return strconv.FormatUint(n.bits, 10)
+	default:
+		return strconv.FormatInt(int64(n.bits), 10)
+	}
+}
+
+// Interface returns the number as the Go type that corresponds to the
+// code that it was encoded with. This is the same value that would be
+// produced when decoding into an interface{} without the UseNumber option
+func (n Number) Interface() interface{} {
+	switch n.code {
+	case Int16:
+		return int16(n.bits)
+	case Int32:
+		return int32(n.bits)
+	case Int64:
+		return int64(n.bits)
+	case Uint8:
+		return uint8(n.bits)
+	case Uint16:
+		return uint16(n.bits)
+	case Uint32:
+		return uint32(n.bits)
+	case Uint64:
+		return n.bits
+	case Float:
+		return math.Float32frombits(uint32(n.bits))
+	case Double:
+		return math.Float64frombits(n.bits)
+	default: // fixnums and Int8
+		return int8(n.bits)
+	}
+}
+
+// normalize returns the number as an int64 for signed integers and
+// fixnums, as a uint64 for unsigned integers, and as is for floats
+func (n Number) normalize() interface{} {
+	switch {
+	case n.IsFloat():
+		return n.Interface()
+	case n.isUnsigned():
+		return n.bits
+	default:
+		return int64(n.bits)
+	}
+}
+
+// EncodeMsgpack encodes the number using the same code that it was
+// decoded from
+func (n Number) EncodeMsgpack(e Encoder) error {
+	w := e.Writer()
+
+	var err error
+	switch n.code {
+	case Int8, Uint8:
+		err = w.WriteByteUint8(n.code.Byte(), uint8(n.bits))
+	case Int16, Uint16:
+		err = w.WriteByteUint16(n.code.Byte(), uint16(n.bits))
+	case Int32, Uint32, Float:
+		err = w.WriteByteUint32(n.code.Byte(), uint32(n.bits))
+	case Int64, Uint64, Double:
+		err = w.WriteByteUint64(n.code.Byte(), n.bits)
+	default:
+		err = w.WriteByte(n.code.Byte())
+	}
+	if err != nil {
+		return errors.Wrap(err, `msgpack: failed to write number`)
+	}
+	return nil
+}
+
+// DecodeMsgpack decodes any integer or floating point number
+func (n *Number) DecodeMsgpack(d Decoder) error {
+	code, err := d.ReadCode()
+	if err != nil {
+		return errors.Wrap(err, `msgpack: failed to read code`)
+	}
+
+	r := d.Reader()
+	var bits uint64
+	switch code {
+	case Int8:
+		x, err := r.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for Int8`)
+		}
+		bits = uint64(int8(x))
+	case Int16:
+		x, err := r.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for Int16`)
+		}
+		bits = uint64(int16(x))
+	case Int32:
+		x, err := r.ReadUint32()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for Int32`)
+		}
+		bits = uint64(int32(x))
+	case Int64, Uint64, Double:
+		bits, err = r.ReadUint64()
+		if err != nil {
+			return errors.Wrapf(err, `msgpack: failed to read payload for %s`, code)
+		}
+	case Uint8:
+		x, err := r.ReadUint8()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for Uint8`)
+		}
+		bits = uint64(x)
+	case Uint16:
+		x, err := r.ReadUint16()
+		if err != nil {
+			return errors.Wrap(err, `msgpack: failed to read payload for Uint16`)
+		}
+		bits = uint64(x)
+	case Uint32, Float:
+		x, err := r.ReadUint32()
+		if err != nil {
+			return errors.Wrapf(err, `msgpack: failed to read payload for %s`, code)
+		}
+		bits = uint64(x)
+	default:
+		if !IsFixNumFamily(code) {
+			return errors.Errorf(`msgpack: invalid code: expected a number, got %s`, code)
+		}
+		bits = uint64(int8(code))
+	}
+
+	n.code = code
+	n.bits = bits
+	return nil
+}
@@ -0,0 +1,189 @@
+package msgpack_test
+
+import (
+	"math"
+	"testing"
+
+	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeNumber(t *testing.T, v interface{}) (msgpack.Number, bool) {
+	b, err := msgpack.Marshal(v)
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return msgpack.Number{}, false
+	}
+
+	var n msgpack.Number
+	if !assert.NoError(t, msgpack.Unmarshal(b, &n), "Unmarshal should succeed") {
+		return msgpack.Number{}, false
+	}
+	return n, true
+}
+
+func TestNumber(t *testing.T) {
+	t.Parallel()
+
+	testcases := []struct {
+		Input   interface{}
+		Code    msgpack.Code
+		String  string
+		Int64   int64
+		Uint64  uint64
+		Float64 float64
+		// Errors for Int64, Uint64, and Float64
+		Errors [3]bool
+	}{
+		{Input: int8(-5), Code: msgpack.Code(0xfb), String: "-5", Int64: -5, Float64: -5, Errors: [3]bool{false, true, false}},
+		{Input: int8(-100), Code: msgpack.Int8, String: "-100", Int64: -100, Float64: -100, Errors: [3]bool{false, true, false}},
+		{Input: int16(1000), Code: msgpack.Int16, String: "1000", Int64: 1000, Uint64: 1000, Float64: 1000},
+		{Input: int64(math.MinInt64), Code: msgpack.Int64, String: "-9223372036854775808", Int64: math.MinInt64, Float64: math.MinInt64, Errors: [3]bool{false, true, false}},
+		{Input: int64(math.MaxInt64), Code: msgpack.Int64, String: "9223372036854775807", Int64: math.MaxInt64, Uint64: math.MaxInt64, Errors: [3]bool{false, false, true}},
+		{Input: uint8(200), Code: msgpack.Uint8, String: "200", Int64: 200, Uint64: 200, Float64: 200},
+		{Input: uint64(math.MaxUint64), Code: msgpack.Uint64, String: "18446744073709551615", Uint64: math.MaxUint64, Errors: [3]bool{true, false, true}},
+		{Input: float32(1.5), Code: msgpack.Float, String: "1.5", Float64: 1.5, Errors: [3]bool{true, true, false}},
+		{Input: float64(-3), Code: msgpack.Double, String: "-3", Int64: -3, Float64: -3, Errors: [3]bool{false, true, false}},
+		{Input: float64(1e18), Code: msgpack.Double, String: "1e+18", Int64: 1e18, Uint64: 1e18, Float64: 1e18},
+		{Input: float64(1e20), Code: msgpack.Double, String: "1e+20", Float64: 1e20, Errors: [3]bool{true, true, false}},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.String, func(t *testing.T) {
+			t.Parallel()
+			n, ok := decodeNumber(t, tc.Input)
+			if !ok {
+				return
+			}
+
+			assert.Equal(t, tc.Code, n.Code(), "Code should match")
+			assert.Equal(t, tc.String, n.String(), "String should match")
+			assert.Equal(t, tc.Input, n.Interface(), "Interface should match")
+
+			i, err := n.Int64()
+			if tc.Errors[0] {
+				assert.Error(t, err, "Int64 should fail")
+			} else if assert.NoError(t, err, "Int64 should succeed") {
+				assert.Equal(t, tc.Int64, i, "Int64 should match")
+			}
+
+			u, err := n.Uint64()
+			if tc.Errors[1] {
+				assert.Error(t, err, "Uint64 should fail")
+			} else if assert.NoError(t, err, "Uint64 should succeed") {
+				assert.Equal(t, tc.Uint64, u, "Uint64 should match")
+			}
+
+			f, err := n.Float64()
+			if tc.Errors[2] {
+				assert.Error(t, err, "Float64 should fail")
+			} else if assert.NoError(t, err, "Float64 should succeed") {
+				assert.Equal(t, tc.Float64, f, "Float64 should match")
+			}
+
+			// Re-encoding preserves the representation
+			expected, err := msgpack.Marshal(tc.Input)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+			b, err := msgpack.Marshal(n)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+			assert.Equal(t, expected, b, "re-encoded number should match")
+		})
+	}
+
+	t.Run("zero value", func(t *testing.T) {
+		t.Parallel()
+		var n msgpack.Number
+		i, err := n.Int64()
+		if assert.NoError(t, err, "Int64 should succeed") {
+			assert.Equal(t, int64(0), i, "zero value should be 0")
+		}
+	})
+	t.Run("not a number", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal("foo")
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		var n msgpack.Number
+		assert.Error(t, msgpack.Unmarshal(b, &n), "Unmarshal should fail")
+	})
+}
+
+func TestDecodeNumberOptions(t *testing.T) {
+	t.Parallel()
+
+	b, err := msgpack.Marshal([]interface{}{int8(1), int16(-1000), uint32(1000), float32(0.5), "foo"})
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		var v interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, []interface{}{int8(1), int16(-1000), uint32(1000), float32(0.5), "foo"}, v, "values should match")
+	})
+	t.Run("WithUseNumber", func(t *testing.T) {
+		t.Parallel()
+		var v []interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v, msgpack.WithUseNumber(true)), "Unmarshal should succeed") {
+			return
+		}
+		if !assert.Len(t, v, 5, "length should match") {
+			return
+		}
+		for i, expected := range []string{"1", "-1000", "1000", "0.5"} {
+			n, ok := v[i].(msgpack.Number)
+			if assert.True(t, ok, "element %d should be a Number", i) {
+				assert.Equal(t, expected, n.String(), "element %d should match", i)
+			}
+		}
+		assert.Equal(t, "foo", v[4], "non-numbers should not be affected")
+	})
+	t.Run("WithUseNumber and named types", func(t *testing.T) {
+		t.Parallel()
+		type container struct {
+			Int   namedInt
+			Float namedFloat
+			Any   interface{}
+		}
+		b, err := msgpack.Marshal(container{Int: 42, Float: 1.5, Any: int16(-1000)})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var v container
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v, msgpack.WithUseNumber(true)), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, namedInt(42), v.Int, "named int should be decoded")
+		assert.Equal(t, namedFloat(1.5), v.Float, "named float should be decoded")
+		if n, ok := v.Any.(msgpack.Number); assert.True(t, ok, "interface{} field should be a Number") {
+			assert.Equal(t, "-1000", n.String(), "interface{} field should match")
+		}
+
+		b, err = msgpack.Marshal(namedInt(-7))
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		var i namedInt
+		if !assert.NoError(t, msgpack.Unmarshal(b, &i, msgpack.WithUseNumber(true)), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, namedInt(-7), i, "top-level named int should be decoded")
+	})
+	t.Run("WithNormalizeIntegers", func(t *testing.T) {
+		t.Parallel()
+		var v interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v, msgpack.WithNormalizeIntegers(true)), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, []interface{}{int64(1), int64(-1000), uint64(1000), float32(0.5), "foo"}, v, "values should match")
+	})
+}
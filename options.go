@@ -0,0 +1,214 @@
+package msgpack
+
+// Option is used to pass optional parameters to Encoders and
+// Decoders upon construction
+type Option interface {
+	Name() string
+	Value() interface{}
+}
+
+type option struct {
+	name  string
+	value interface{}
+}
+
+func (o *option) Name() string {
+	return o.name
+}
+
+func (o *option) Value() interface{} {
+	return o.value
+}
+
+const (
+	optkeyBinAsString           = `bin-as-string`
+	optkeyBufferSize            = `buffer-size`
+	optkeyCanonical             = `canonical`
+	optkeyCaseInsensitiveFields = `case-insensitive-fields`
+	optkeyDisallowUnknownFields = `disallow-unknown-fields`
+	optkeyMapKeyMode            = `map-key-mode`
+	optkeyMapType               = `map-type`
+	optkeyMaxDepth              = `max-depth`
+	optkeyMaxElements           = `max-elements`
+	optkeyMaxLength             = `max-length`
+	optkeyMaxTotalBytes         = `max-total-bytes`
+	optkeyNormalizeIntegers     = `normalize-integers`
+	optkeySpillThreshold        = `spill-threshold`
+	optkeyStructAsArray         = `struct-as-array`
+	optkeyUseNumber             = `use-number`
+	optkeyZeroCopy              = `zero-copy`
+)
+
+// MapKeyMode specifies how maps with keys that are not all strings
+// are decoded when the destination is an interface{}
+type MapKeyMode int
+
+const (
+	// MapKeyInterface decodes maps with non-string keys into
+	// map[interface{}]interface{}. This is the default
+	MapKeyInterface MapKeyMode = iota
+	// MapKeyStringify converts non-string keys to strings using
+	// fmt.Sprint, so that maps always decode into map[string]interface{}
+	MapKeyStringify
+	// MapKeyStrict causes an error to be returned when a map with
+	// non-string keys is encountered
+	MapKeyStrict
+)
+
+// MapType specifies the Go type that maps are decoded into when the
+// destination is an interface{}
+type MapType int
+
+const (
+	// MapTypeString decodes maps into map[string]interface{}. Maps with
+	// keys that are not strings are handled according to the MapKeyMode.
+	// This is the default
+	MapTypeString MapType = iota
+	// MapTypeInterface always decodes maps into map[interface{}]interface{}
+	MapTypeInterface
+	// MapTypeOrdered decodes maps into OrderedMap, which preserves
+	// the order of the elements
+	MapTypeOrdered
+)
+
+// WithMapType specifies the Go type that maps are decoded into when
+// the destination is an interface{}. This also applies to maps that
+// are nested in other values
+func WithMapType(typ MapType) Option {
+	return &option{name: optkeyMapType, value: typ}
+}
+
+// WithMapKeyMode specifies what maps decode into when the destination
+// is an interface{} and the keys are not all strings. Maps whose keys
+// are all strings always decode into map[string]interface{}
+func WithMapKeyMode(mode MapKeyMode) Option {
+	return &option{name: optkeyMapKeyMode, value: mode}
+}
+
+// WithStructAsArray specifies that the Encoder should encode all
+// structs as arrays of their field values, in the order that they are
+// declared, instead of maps keyed by field names. This produces more
+// compact output, but the decoding side must know the field order.
+//
+// Individual struct types can opt in to this behavior by declaring
+// a blank field with the toarray tag option:
+//
+//	type Point struct {
+//	  _ struct{} `msgpack:",toarray"`
+//	  X int
+//	  Y int
+//	}
+func WithStructAsArray(b bool) Option {
+	return &option{name: optkeyStructAsArray, value: b}
+}
+
+// WithDisallowUnknownFields specifies that the Decoder should return
+// an UnknownFieldError when it encounters a map key that does not
+// correspond to any field in the destination struct, instead of
+// silently discarding the value
+func WithDisallowUnknownFields(b bool) Option {
+	return &option{name: optkeyDisallowUnknownFields, value: b}
+}
+
+// WithCaseInsensitiveFields specifies that the Decoder should fall back
+// to case-insensitive matching when a map key does not exactly match
+// the name or alias of any field in the destination struct, in the
+// same way as encoding/json
+func WithCaseInsensitiveFields(b bool) Option {
+	return &option{name: optkeyCaseInsensitiveFields, value: b}
+}
+
+// WithUseNumber specifies that the Decoder should decode numbers into
+// Number instead of the Go numeric type that corresponds to their
+// msgpack code, when the destination is an interface{}
+func WithUseNumber(b bool) Option {
+	return &option{name: optkeyUseNumber, value: b}
+}
+
+// WithNormalizeIntegers specifies that the Decoder should decode signed
+// integers as int64, and unsigned integers as uint64, regardless of
+// their width on the wire, when the destination is an interface{}.
+// Positive and negative fixnums are decoded as int64. Floating point
+// numbers are not affected. WithUseNumber takes precedence over this
+// option
+func WithNormalizeIntegers(b bool) Option {
+	return &option{name: optkeyNormalizeIntegers, value: b}
+}
+
+// WithBinAsString specifies that the Decoder should decode bin values
+// into string instead of []byte, when the destination is an interface{}
+func WithBinAsString(b bool) Option {
+	return &option{name: optkeyBinAsString, value: b}
+}
+
+// WithMaxLength specifies the maximum length of bin, str and ext
+// payloads that the Decoder accepts. Longer payloads result in a
+// LimitError before any memory is allocated for them.
+// Zero means unlimited
+func WithMaxLength(n int) Option {
+	return &option{name: optkeyMaxLength, value: n}
+}
+
+// WithMaxElements specifies the maximum number of elements in an array
+// or a map that the Decoder accepts. Larger arrays and maps result in
+// a LimitError before any memory is allocated for them.
+// Zero means unlimited
+func WithMaxElements(n int) Option {
+	return &option{name: optkeyMaxElements, value: n}
+}
+
+// WithMaxDepth specifies the maximum nesting depth of the values that
+// the Decoder accepts. Deeper values result in a LimitError. Every
+// value counts as a level, so a string inside of an array inside of a
+// map has a depth of 3. Zero means unlimited
+func WithMaxDepth(n int) Option {
+	return &option{name: optkeyMaxDepth, value: n}
+}
+
+// WithMaxTotalBytes specifies the maximum number of bytes that the
+// Decoder reads from its source. Reading past the limit results in a
+// LimitError. The count is reset when SetSource is called.
+// Zero means unlimited
+func WithMaxTotalBytes(n int64) Option {
+	return &option{name: optkeyMaxTotalBytes, value: n}
+}
+
+// WithZeroCopy specifies that a Decoder that reads from a byte slice
+// (see NewDecoderBytes) should avoid copying data out of the slice:
+// []byte and RawMessage values alias the input, and strings are
+// created without copying. The input must therefore not be modified
+// (or unmapped) for as long as the decoded values are in use.
+// This option has no effect on Decoders that read from an io.Reader
+func WithZeroCopy(b bool) Option {
+	return &option{name: optkeyZeroCopy, value: b}
+}
+
+// WithBufferSize specifies the size of the buffer that an Encoder uses
+// when writing to an io.Writer. The default is DefaultEncoderBufferSize.
+// If n is 0, the output is not buffered, and each small write is
+// forwarded to the io.Writer as-is
+func WithBufferSize(n int) Option {
+	return &option{name: optkeyBufferSize, value: n}
+}
+
+// WithCanonical specifies that an Encoder should produce the canonical
+// form of values, so that equal values are always encoded to the same
+// bytes: map keys are sorted, integers and lengths use the smallest
+// possible representation, and floating point numbers are normalized.
+// See Canonicalize for the details.
+//
+// Note that values that implement EncodeMsgpacker are responsible for
+// their own output
+func WithCanonical(b bool) Option {
+	return &option{name: optkeyCanonical, value: b}
+}
+
+// WithSpillThreshold specifies the number of bytes that an array or
+// a map started with Encoder.BeginArray or Encoder.BeginMap may buffer
+// in memory, before the elements are moved to a temporary file. Elements
+// are only buffered if the destination is not seekable. If n is 0, the
+// elements are always kept in memory.
+// The default is DefaultSpillThreshold
+func WithSpillThreshold(n int) Option {
+	return &option{name: optkeySpillThreshold, value: n}
+}
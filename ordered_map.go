@@ -0,0 +1,86 @@
+package msgpack
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// MapItem is a single key/value pair in an OrderedMap
+type MapItem struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// OrderedMap is a msgpack map that remembers the order of its
+// elements. Since the keys are not hashed, keys of any type (including
+// []byte, arrays and maps) can be represented. OrderedMap values are
+// encoded with their elements in order, and decoding into an OrderedMap
+// preserves the order on the wire.
+type OrderedMap []MapItem
+
+// Get returns the value for the first element whose key is equal to
+// key. Keys are compared using reflect.DeepEqual
+func (m OrderedMap) Get(key interface{}) (interface{}, bool) {
+	for _, item := range m {
+		if reflect.DeepEqual(item.Key, key) {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Keys returns the keys of the map in order
+func (m OrderedMap) Keys() []interface{} {
+	keys := make([]interface{}, len(m))
+	for i, item := range m {
+		keys[i] = item.Key
+	}
+	return keys
+}
+
+// EncodeMsgpack encodes the map, preserving the order of its elements
+func (m OrderedMap) EncodeMsgpack(e Encoder) error {
+	if m == nil {
+		return e.EncodeNil()
+	}
+
+	if err := WriteMapHeader(e.Writer(), len(m)); err != nil {
+		return errors.Wrap(err, `msgpack: failed to write map header`)
+	}
+
+	for i, item := range m {
+		if err := e.Encode(item.Key); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to encode map key at index %d`, i)
+		}
+		if err := e.Encode(item.Value); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to encode map value for key %v`, item.Key)
+		}
+	}
+	return nil
+}
+
+// DecodeMsgpack decodes a map, preserving the order of its elements
+func (m *OrderedMap) DecodeMsgpack(d Decoder) error {
+	var size int
+	if err := d.DecodeMapLength(&size); err != nil {
+		return errors.Wrap(err, `msgpack: failed to decode map length`)
+	}
+
+	if size == -1 {
+		*m = nil
+		return nil
+	}
+
+	om := make(OrderedMap, size)
+	for i := range om {
+		if err := d.Decode(&om[i].Key); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to decode map key at index %d`, i)
+		}
+		if err := d.Decode(&om[i].Value); err != nil {
+			return errors.Wrapf(err, `msgpack: failed to decode map value for key %v`, om[i].Key)
+		}
+	}
+	*m = om
+	return nil
+}
@@ -0,0 +1,10 @@
+package msgpack
+
+// RawMessage is a raw, already encoded msgpack value. It can be used
+// to delay decoding a value, or to pass a value through untouched.
+//
+// When decoding into a RawMessage, the verbatim bytes that make up
+// the next value are stored. When encoding a RawMessage, its contents
+// are written as is, without any validation. An empty RawMessage is
+// encoded as nil.
+type RawMessage []byte
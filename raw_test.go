@@ -0,0 +1,107 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"testing"
+
+	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+type rawEnvelope struct {
+	Kind string
+	Body msgpack.RawMessage
+}
+
+func TestRawMessage(t *testing.T) {
+	t.Parallel()
+
+	body := map[string]interface{}{
+		"list": []interface{}{1, "two", []byte("three")},
+		"ext":  negativeExt{Value: 0xcafe},
+	}
+	encodedBody, err := msgpack.Marshal(body)
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+
+	t.Run("Decode and Encode", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		buf.Write(encodedBody)
+		if !assert.NoError(t, msgpack.NewEncoder(&buf).EncodeString("sentinel"), "EncodeString should succeed") {
+			return
+		}
+
+		dec := msgpack.NewDecoder(&buf)
+		var raw msgpack.RawMessage
+		if !assert.NoError(t, dec.Decode(&raw), "Decode should succeed") {
+			return
+		}
+		if !assert.Equal(t, msgpack.RawMessage(encodedBody), raw, "raw message should match") {
+			return
+		}
+
+		var s string
+		if !assert.NoError(t, dec.DecodeString(&s), "DecodeString should succeed") {
+			return
+		}
+		if !assert.Equal(t, "sentinel", s, "value after raw message should be decoded") {
+			return
+		}
+
+		b, err := msgpack.Marshal(raw)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		if !assert.Equal(t, encodedBody, b, "raw message should be written verbatim") {
+			return
+		}
+	})
+	t.Run("struct field", func(t *testing.T) {
+		t.Parallel()
+		v := rawEnvelope{
+			Kind: "test",
+			Body: msgpack.RawMessage(encodedBody),
+		}
+		b, err := msgpack.Marshal(v)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var r rawEnvelope
+		if !assert.NoError(t, msgpack.Unmarshal(b, &r), "Unmarshal should succeed") {
+			return
+		}
+		if !assert.Equal(t, v, r, "RoundTrip should succeed") {
+			return
+		}
+	})
+	t.Run("map value", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(map[string]interface{}{
+			"body": msgpack.RawMessage(encodedBody),
+		})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var m map[string]interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &m), "Unmarshal should succeed") {
+			return
+		}
+		if !assert.Equal(t, []interface{}{int64(1), "two", []byte("three")}, m["body"].(map[string]interface{})["list"], "nested value should be decoded") {
+			return
+		}
+	})
+	t.Run("empty", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(msgpack.RawMessage(nil))
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		if !assert.Equal(t, []byte{msgpack.Nil.Byte()}, b, "empty raw message should be encoded as nil") {
+			return
+		}
+	})
+}
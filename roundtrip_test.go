@@ -1,12 +1,16 @@
 package msgpack_test
 
 import (
+	"fmt"
 	"math"
+	"math/big"
+	"net"
 	"reflect"
 	"testing"
 	"time"
 
 	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -69,3 +73,581 @@ func TestRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+type namedInt int
+
+type allFieldTypes struct {
+	String  string
+	Bool    bool
+	Int     int
+	Int8    int8
+	Int16   int16
+	Int32   int32
+	Int64   int64
+	Uint    uint
+	Uint8   uint8
+	Uint16  uint16
+	Uint32  uint32
+	Uint64  uint64
+	Float32 float32
+	Float64 float64
+	Bytes   []byte
+	Named   namedInt
+	Strings []string
+	Ptr     *dummyStruct
+	Struct  dummyStruct
+	Iface   interface{}
+}
+
+func TestRoundTripStruct(t *testing.T) {
+	t.Parallel()
+
+	v := allFieldTypes{
+		String:  "Hello",
+		Bool:    true,
+		Int:     -1000,
+		Int8:    math.MinInt8,
+		Int16:   math.MinInt16,
+		Int32:   math.MinInt32,
+		Int64:   math.MinInt64,
+		Uint:    1000,
+		Uint8:   math.MaxUint8,
+		Uint16:  math.MaxUint16,
+		Uint32:  math.MaxUint32,
+		Uint64:  math.MaxUint64,
+		Float32: math.MaxFloat32,
+		Float64: math.MaxFloat64,
+		Bytes:   []byte("World"),
+		Named:   namedInt(42),
+		Strings: []string{"uno", "dos"},
+		Ptr:     &dummyStruct{Message: "pointer"},
+		Struct:  dummyStruct{Message: "struct"},
+		Iface:   "interface",
+	}
+
+	// Run it multiple times, so that we exercise the cached plan
+	for i := 0; i < 3; i++ {
+		b, err := msgpack.Marshal(v)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var r allFieldTypes
+		if !assert.NoError(t, msgpack.Unmarshal(b, &r), "Unmarshal should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, v, r, "RoundTrip should succeed") {
+			return
+		}
+	}
+}
+
+type namedFloat float64
+type namedString string
+type namedBool bool
+type intList []int
+type namedIntList []namedInt
+
+func TestRoundTripNamedTypes(t *testing.T) {
+	t.Parallel()
+
+	var list = []interface{}{
+		namedInt(-42),
+		namedFloat(3.5),
+		namedString("foo"),
+		namedBool(true),
+		intList{1, 2, 3},
+		namedIntList{4, 5, 6},
+		[]namedString{"foo", "bar"},
+		[3]namedInt{1, 2, 3},
+	}
+
+	for _, data := range list {
+		data := data
+		t.Run(reflect.TypeOf(data).String(), func(t *testing.T) {
+			t.Parallel()
+			b, err := msgpack.Marshal(data)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+
+			var v interface{} = reflect.New(reflect.TypeOf(data)).Interface()
+			if !assert.NoError(t, msgpack.Unmarshal(b, v), "Unmarshal should succeed") {
+				return
+			}
+
+			if !assert.Equal(t, data, reflect.ValueOf(v).Elem().Interface(), "RoundTrip should succeed") {
+				return
+			}
+		})
+	}
+
+	t.Run("encoded as underlying type", func(t *testing.T) {
+		t.Parallel()
+		named, err := msgpack.Marshal(intList{1, 2, 3})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		builtin, err := msgpack.Marshal([]int{1, 2, 3})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		assert.Equal(t, builtin, named, "named slice should be encoded like its underlying type")
+	})
+}
+
+func TestRoundTripMapKeys(t *testing.T) {
+	t.Parallel()
+
+	var list = []interface{}{
+		map[int]string{-1: "minus one", 0: "zero", 1000: "thousand"},
+		map[uint64]string{0: "zero", math.MaxUint64: "max"},
+		map[[16]byte]int{{0x1}: 1, {0xf, 0xf}: 2},
+		map[interface{}]interface{}{"foo": "bar", int64(1): int64(2)},
+		map[int]*dummyStruct{1: {Message: "uno"}},
+	}
+
+	for _, data := range list {
+		data := data
+		t.Run(reflect.TypeOf(data).String(), func(t *testing.T) {
+			t.Parallel()
+			b, err := msgpack.Marshal(data)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+
+			var v interface{} = reflect.New(reflect.TypeOf(data)).Interface()
+			if !assert.NoError(t, msgpack.Unmarshal(b, v), "Unmarshal should succeed") {
+				return
+			}
+
+			if !assert.Equal(t, data, reflect.ValueOf(v).Elem().Interface(), "RoundTrip should succeed") {
+				return
+			}
+		})
+	}
+}
+
+type labelMap map[string]string
+
+type labeledStruct struct {
+	Name   string            `msgpack:"name"`
+	Labels map[string]string `msgpack:"labels"`
+	Named  labelMap          `msgpack:"named"`
+}
+
+func TestRoundTripTypedMaps(t *testing.T) {
+	t.Parallel()
+
+	var list = []interface{}{
+		map[string]int{"one": 1, "two": 2},
+		map[string]*dummyStruct{"foo": {Message: "bar"}, "nil": nil},
+		map[string][]string{"list": {"a", "b"}},
+		labelMap{"app": "msgpack"},
+		labeledStruct{
+			Name:   "foo",
+			Labels: map[string]string{"env": "prod"},
+			Named:  labelMap{"tier": "db"},
+		},
+	}
+
+	for _, data := range list {
+		data := data
+		t.Run(reflect.TypeOf(data).String(), func(t *testing.T) {
+			t.Parallel()
+			b, err := msgpack.Marshal(data)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+
+			var v interface{} = reflect.New(reflect.TypeOf(data)).Interface()
+			if !assert.NoError(t, msgpack.Unmarshal(b, v), "Unmarshal should succeed") {
+				return
+			}
+
+			if !assert.Equal(t, data, reflect.ValueOf(v).Elem().Interface(), "RoundTrip should succeed") {
+				return
+			}
+		})
+	}
+
+	t.Run("existing map is reused", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(map[string]int{"two": 2})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		m := map[string]int{"one": 1}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &m), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, map[string]int{"one": 1, "two": 2}, m, "maps should be merged")
+	})
+}
+
+type uuid [16]byte
+
+type vectorStruct struct {
+	ID     uuid       `msgpack:"id"`
+	Vector [3]float64 `msgpack:"vector"`
+	Points [2]*dummyStruct
+}
+
+func TestRoundTripArrays(t *testing.T) {
+	t.Parallel()
+
+	var list = []interface{}{
+		[3]float64{1.5, 2.5, 3.5},
+		[2]string{"foo", "bar"},
+		[0]int{},
+		[4]byte{0xde, 0xad, 0xbe, 0xef},
+		uuid{0x1, 0x2, 0x3},
+		[2][2]int{{1, 2}, {3, 4}},
+		[3]namedInt{1, 2, 3},
+		stringList{"foo", "bar"},
+		vectorStruct{
+			ID:     uuid{0xf},
+			Vector: [3]float64{0.1, 0.2, 0.3},
+			Points: [2]*dummyStruct{{Message: "foo"}, nil},
+		},
+	}
+
+	for _, data := range list {
+		data := data
+		t.Run(reflect.TypeOf(data).String(), func(t *testing.T) {
+			t.Parallel()
+			b, err := msgpack.Marshal(data)
+			if !assert.NoError(t, err, "Marshal should succeed") {
+				return
+			}
+
+			var v interface{} = reflect.New(reflect.TypeOf(data)).Interface()
+			if !assert.NoError(t, msgpack.Unmarshal(b, v), "Unmarshal should succeed") {
+				return
+			}
+
+			if !assert.Equal(t, data, reflect.ValueOf(v).Elem().Interface(), "RoundTrip should succeed") {
+				return
+			}
+		})
+	}
+
+	t.Run("byte arrays are encoded as bin", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(uuid{})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		assert.Equal(t, msgpack.Bin8.Byte(), b[0], "code should be Bin8")
+	})
+
+	t.Run("length mismatch", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal([]float64{1, 2})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var v [3]float64
+		assert.Error(t, msgpack.Unmarshal(b, &v), "Unmarshal should fail")
+
+		b, err = msgpack.Marshal([]byte{1, 2})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var id uuid
+		assert.Error(t, msgpack.Unmarshal(b, &id), "Unmarshal should fail")
+	})
+}
+
+type arrayPoint struct {
+	_ struct{} `msgpack:",toarray"`
+	X int
+	Y int
+	Z string `msgpack:"z,omitempty"`
+}
+
+func TestRoundTripStructAsArray(t *testing.T) {
+	t.Parallel()
+
+	t.Run("toarray tag", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(arrayPoint{X: 1, Y: 2})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		assert.Equal(t, msgpack.FixArray3.Byte(), b[0], "struct should be encoded as an array")
+
+		var v arrayPoint
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, arrayPoint{X: 1, Y: 2}, v, "RoundTrip should succeed")
+	})
+	t.Run("WithStructAsArray", func(t *testing.T) {
+		t.Parallel()
+		data := dummyStruct{Message: "Hello, World!"}
+		b, err := msgpack.Marshal(data, msgpack.WithStructAsArray(true))
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		assert.Equal(t, msgpack.FixArray1.Byte(), b[0], "struct should be encoded as an array")
+
+		var v dummyStruct
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, data, v, "RoundTrip should succeed")
+	})
+	t.Run("decode from map", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(map[string]interface{}{"X": 1, "Y": 2})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var v arrayPoint
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, arrayPoint{X: 1, Y: 2}, v, "Unmarshal should accept maps")
+	})
+	t.Run("extra and missing values", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal([]interface{}{1, 2, "foo", "extra"})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var v arrayPoint
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, arrayPoint{X: 1, Y: 2, Z: "foo"}, v, "extra values should be discarded")
+
+		b, err = msgpack.Marshal([]interface{}{3})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		v = arrayPoint{Y: 5}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, arrayPoint{X: 3, Y: 5}, v, "missing values should be left untouched")
+	})
+}
+
+type Metadata struct {
+	ID      string `msgpack:"id"`
+	Version int    `msgpack:"version"`
+}
+
+type Timestamps struct {
+	Created int64
+	Updated int64
+}
+
+type embeddingStruct struct {
+	Metadata
+	*Timestamps
+	Name string `msgpack:"name"`
+}
+
+type shadowingStruct struct {
+	Metadata
+	ID string `msgpack:"id"` // shadows Metadata.ID
+}
+
+type namedEmbedStruct struct {
+	Metadata `msgpack:"meta"`
+	Name     string
+}
+
+type conflictA struct{ Conflict string }
+type conflictB struct{ Conflict string }
+type conflictingStruct struct {
+	conflictA
+	conflictB
+	Name string
+}
+
+func TestEmbeddedStruct(t *testing.T) {
+	t.Parallel()
+
+	decodeGeneric := func(t *testing.T, v interface{}) map[string]interface{} {
+		b, err := msgpack.Marshal(v)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return nil
+		}
+		var m map[string]interface{}
+		if !assert.NoError(t, msgpack.Unmarshal(b, &m), "Unmarshal should succeed") {
+			return nil
+		}
+		return m
+	}
+
+	t.Run("fields are promoted", func(t *testing.T) {
+		t.Parallel()
+		data := embeddingStruct{
+			Metadata:   Metadata{ID: "foo", Version: 1},
+			Timestamps: &Timestamps{Created: 100, Updated: 200},
+			Name:       "bar",
+		}
+		m := decodeGeneric(t, data)
+		assert.Equal(t, map[string]interface{}{
+			"id":      "foo",
+			"version": int64(1),
+			"Created": int64(100),
+			"Updated": int64(200),
+			"name":    "bar",
+		}, m, "embedded fields should be flattened")
+
+		b, err := msgpack.Marshal(data)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		var v embeddingStruct
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, data, v, "embedded pointers should be allocated")
+	})
+	t.Run("nil embedded pointer", func(t *testing.T) {
+		t.Parallel()
+		data := embeddingStruct{Name: "bar"}
+		m := decodeGeneric(t, data)
+		assert.Equal(t, map[string]interface{}{
+			"id":      "",
+			"version": int64(0),
+			"name":    "bar",
+		}, m, "fields of nil embedded pointers should be omitted")
+
+		b, err := msgpack.Marshal(data)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		var v embeddingStruct
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+			return
+		}
+		assert.Nil(t, v.Timestamps, "embedded pointer should not be allocated")
+	})
+	t.Run("shadowing", func(t *testing.T) {
+		t.Parallel()
+		data := shadowingStruct{Metadata: Metadata{ID: "inner", Version: 2}, ID: "outer"}
+		m := decodeGeneric(t, data)
+		assert.Equal(t, map[string]interface{}{
+			"id":      "outer",
+			"version": int64(2),
+		}, m, "outer field should win")
+	})
+	t.Run("tagged embedded struct", func(t *testing.T) {
+		t.Parallel()
+		data := namedEmbedStruct{Metadata: Metadata{ID: "foo"}, Name: "bar"}
+		m := decodeGeneric(t, data)
+		assert.Equal(t, map[string]interface{}{
+			"meta": map[string]interface{}{"id": "foo", "version": int64(0)},
+			"Name": "bar",
+		}, m, "tagged embedded struct should not be flattened")
+	})
+	t.Run("conflicting fields", func(t *testing.T) {
+		t.Parallel()
+		data := conflictingStruct{conflictA{"a"}, conflictB{"b"}, "foo"}
+		m := decodeGeneric(t, data)
+		assert.Equal(t, map[string]interface{}{"Name": "foo"}, m, "conflicting fields should be ignored")
+	})
+}
+
+// binaryPoint implements encoding.BinaryMarshaler and encoding.TextMarshaler
+type binaryPoint struct {
+	X, Y uint8
+}
+
+func (p binaryPoint) MarshalBinary() ([]byte, error) {
+	return []byte{p.X, p.Y}, nil
+}
+
+func (p *binaryPoint) UnmarshalBinary(b []byte) error {
+	if len(b) != 2 {
+		return errors.New(`invalid length`)
+	}
+	p.X, p.Y = b[0], b[1]
+	return nil
+}
+
+func (p binaryPoint) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d,%d", p.X, p.Y)), nil
+}
+
+func (p *binaryPoint) UnmarshalText(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "%d,%d", &p.X, &p.Y)
+	return err
+}
+
+type marshalerStruct struct {
+	IP    net.IP      `msgpack:"ip"`
+	Int   *big.Int    `msgpack:"int"`
+	Point binaryPoint `msgpack:"point"`
+	Time  time.Time   `msgpack:"time"`
+}
+
+func TestRoundTripMarshalers(t *testing.T) {
+	t.Parallel()
+
+	t.Run("struct fields", func(t *testing.T) {
+		t.Parallel()
+		data := marshalerStruct{
+			IP:    net.ParseIP("192.168.0.1"),
+			Int:   new(big.Int).Lsh(big.NewInt(1), 100),
+			Point: binaryPoint{X: 1, Y: 2},
+			Time:  time.Unix(1234567890, 0),
+		}
+
+		b, err := msgpack.Marshal(data)
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		var v marshalerStruct
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+			return
+		}
+		assert.True(t, data.IP.Equal(v.IP), "IP should match")
+		assert.Equal(t, 0, data.Int.Cmp(v.Int), "big.Int should match")
+		assert.Equal(t, data.Point, v.Point, "binaryPoint should match")
+		assert.True(t, data.Time.Equal(v.Time), "time.Time should match")
+	})
+	t.Run("wire types", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal(binaryPoint{X: 1, Y: 2})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		assert.Equal(t, []byte{msgpack.Bin8.Byte(), 0x2, 0x1, 0x2}, b, "BinaryMarshaler should be encoded as bin")
+
+		b, err = msgpack.Marshal(net.ParseIP("10.0.0.1"))
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		var s string
+		if !assert.NoError(t, msgpack.Unmarshal(b, &s), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, "10.0.0.1", s, "TextMarshaler should be encoded as str")
+	})
+	t.Run("text into type implementing both", func(t *testing.T) {
+		t.Parallel()
+		b, err := msgpack.Marshal("3,4")
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+		var v binaryPoint
+		if !assert.NoError(t, msgpack.Unmarshal(b, &v), "Unmarshal should succeed") {
+			return
+		}
+		assert.Equal(t, binaryPoint{X: 3, Y: 4}, v, "UnmarshalText should be used for str")
+	})
+}
@@ -0,0 +1,175 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"io"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// rawReader is the buffered source that decoderNL reads from.
+// It is implemented by *bufio.Reader for io.Reader sources, and
+// by *sliceReader for byte slice sources
+type rawReader interface {
+	io.Reader
+	io.ByteScanner
+	Peek(int) ([]byte, error)
+	Discard(int) (int, error)
+}
+
+// sliceReader reads directly from a byte slice, without any
+// intermediate buffering. It implements both rawReader and Reader
+type sliceReader struct {
+	buf []byte
+	pos int
+	// eof is the error returned when reading past the end of buf.
+	// It is a LimitError if buf has been truncated to honor a limit
+	eof error
+}
+
+func newSliceReader(b []byte) *sliceReader {
+	return &sliceReader{buf: b, eof: io.EOF}
+}
+
+// next returns the next n bytes, and advances the position. The
+// returned slice aliases the underlying buffer
+func (r *sliceReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.buf)-r.pos < n {
+		r.pos = len(r.buf)
+		if r.eof == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, r.eof
+	}
+	b := r.buf[r.pos : r.pos+n : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// Read reads len(p) bytes, like the Reader returned by NewReader.
+// If fewer bytes are available, they are read and an error is returned
+func (r *sliceReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.pos >= len(r.buf) {
+		return 0, r.eof
+	}
+	n := copy(p, r.buf[r.pos:])
+	r.pos += n
+	if n < len(p) {
+		if r.eof == io.EOF {
+			return n, io.ErrUnexpectedEOF
+		}
+		return n, r.eof
+	}
+	return n, nil
+}
+
+func (r *sliceReader) ReadByte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, r.eof
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *sliceReader) UnreadByte() error {
+	if r.pos <= 0 {
+		return errors.New(`reader: cannot unread at the beginning of the input`)
+	}
+	r.pos--
+	return nil
+}
+
+func (r *sliceReader) Peek(n int) ([]byte, error) {
+	if len(r.buf)-r.pos < n {
+		return r.buf[r.pos:], r.eof
+	}
+	return r.buf[r.pos : r.pos+n], nil
+}
+
+func (r *sliceReader) Discard(n int) (int, error) {
+	if len(r.buf)-r.pos < n {
+		discarded := len(r.buf) - r.pos
+		r.pos = len(r.buf)
+		return discarded, r.eof
+	}
+	r.pos += n
+	return n, nil
+}
+
+func (r *sliceReader) ReadUint8() (uint8, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return uint8(0), errors.Wrap(err, `reader: failed to read uint8`)
+	}
+	return uint8(b), nil
+}
+
+func (r *sliceReader) ReadUint16() (uint16, error) {
+	b, err := r.next(2)
+	if err != nil {
+		return uint16(0), errors.Wrap(err, `reader: failed to read uint16`)
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func (r *sliceReader) ReadUint32() (uint32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return uint32(0), errors.Wrap(err, `reader: failed to read uint32`)
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (r *sliceReader) ReadUint64() (uint64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return uint64(0), errors.Wrap(err, `reader: failed to read uint64`)
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (r *sliceReader) ReadByteUint8() (byte, uint8, error) {
+	b, err := r.next(2)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, `reader: failed to read 2 bytes`)
+	}
+	return b[0], uint8(b[1]), nil
+}
+
+func (r *sliceReader) ReadByteUint16() (byte, uint16, error) {
+	b, err := r.next(3)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, `reader: failed to read 3 bytes`)
+	}
+	return b[0], binary.BigEndian.Uint16(b[1:]), nil
+}
+
+func (r *sliceReader) ReadByteUint32() (byte, uint32, error) {
+	b, err := r.next(5)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, `reader: failed to read 5 bytes`)
+	}
+	return b[0], binary.BigEndian.Uint32(b[1:]), nil
+}
+
+func (r *sliceReader) ReadByteUint64() (byte, uint64, error) {
+	b, err := r.next(9)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, `reader: failed to read 9 bytes`)
+	}
+	return b[0], binary.BigEndian.Uint64(b[1:]), nil
+}
+
+// unsafeString converts b to a string without copying. b must not be
+// modified afterwards
+func unsafeString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,377 @@
+package msgpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSpillThreshold is the number of bytes that a streaming array
+// or map keeps in memory before spilling to a temporary file, unless
+// overridden by WithSpillThreshold
+const DefaultSpillThreshold = 1 << 20
+
+// patchableWriter is a destination whose already written bytes can
+// be overwritten, such as an *os.File
+type patchableWriter interface {
+	io.Writer
+	io.Seeker
+	io.WriterAt
+}
+
+// streamWriter holds the state of an array or a map that is being
+// written using Encoder.BeginArray or Encoder.BeginMap.
+//
+// If the destination is a patchableWriter, a 32-bit header is written
+// up front, and the element count is patched in when the stream is
+// closed.
+// Otherwise the elements are written to a spillBuffer, which is copied
+// to the destination after the header when the stream is closed
+type streamWriter struct {
+	enl    *encoderNL
+	parent *streamWriter
+	isMap  bool
+	count  int
+	closed bool
+	// err is set if an element was only partially written, in which
+	// case the output can not be used
+	err error
+
+	// patcher and offset are set if the header has been reserved
+	patcher patchableWriter
+	offset  int64
+
+	// spill is set if the elements are being buffered. The previous
+	// destination of the Encoder is restored when the stream is closed
+	spill       *spillBuffer
+	prevDst     Writer
+	prevBuf     *bufio.Writer
+	prevPatcher patchableWriter
+}
+
+type arrayStream struct {
+	*streamWriter
+}
+
+type mapStream struct {
+	*streamWriter
+}
+
+func (enl *encoderNL) BeginArray() (ArrayWriter, error) {
+	s, err := enl.beginStream(false)
+	if err != nil {
+		return nil, err
+	}
+	return arrayStream{s}, nil
+}
+
+func (enl *encoderNL) BeginMap() (MapWriter, error) {
+	s, err := enl.beginStream(true)
+	if err != nil {
+		return nil, err
+	}
+	return mapStream{s}, nil
+}
+
+func (enl *encoderNL) beginStream(isMap bool) (*streamWriter, error) {
+	s := &streamWriter{enl: enl, parent: enl.stream, isMap: isMap}
+
+	// In canonical mode the header must be as small as possible, so
+	// we can not reserve space for it
+	if enl.patcher != nil && !enl.canonical {
+		if err := enl.Flush(); err != nil {
+			return nil, err
+		}
+
+		// Seek may fail even if the destination implements it (e.g.
+		// pipes), and so may WriteAt (e.g. files opened with O_APPEND,
+		// where writes always go to the end of the file). In both
+		// cases we fall back to buffering
+		if offset, ok := patchOffset(enl.patcher); ok {
+			code := Array32
+			if isMap {
+				code = Map32
+			}
+			if err := enl.dst.WriteByteUint32(code.Byte(), 0); err != nil {
+				return nil, errors.Wrap(err, `msgpack: failed to write header`)
+			}
+			s.patcher = enl.patcher
+			s.offset = offset
+			enl.stream = s
+			return s, nil
+		}
+	}
+
+	s.spill = &spillBuffer{threshold: enl.spillThreshold}
+	s.prevDst, s.prevBuf, s.prevPatcher = enl.dst, enl.buf, enl.patcher
+	enl.dst = NewWriter(s.spill)
+	enl.buf = nil
+	enl.patcher = nil
+	enl.stream = s
+	return s, nil
+}
+
+// check makes sure that elements can be added to the stream
+func (s *streamWriter) check() error {
+	if s.closed {
+		return errors.New(`msgpack: stream has already been closed`)
+	}
+	if s.enl.stream != s {
+		return errors.New(`msgpack: a nested array or map is still open`)
+	}
+	if s.err != nil {
+		return errors.Wrap(s.err, `msgpack: stream is unusable after a failed write`)
+	}
+	return nil
+}
+
+// fail marks the stream and the streams that contain it as unusable,
+// and returns err
+func (s *streamWriter) fail(err error) error {
+	for x := s; x != nil; x = x.parent {
+		if x.err == nil {
+			x.err = err
+		}
+	}
+	return err
+}
+
+func (s *streamWriter) Count() int {
+	return s.count
+}
+
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return errors.New(`msgpack: stream has already been closed`)
+	}
+	if s.enl.stream != s {
+		return errors.New(`msgpack: a nested array or map is still open`)
+	}
+	s.closed = true
+	s.enl.stream = s.parent
+	if s.spill != nil {
+		s.restore()
+		defer s.spill.release()
+	}
+
+	// The parent has already counted this stream as one of its
+	// elements, so it is incomplete if this stream is
+	if err := s.finish(); err != nil {
+		if s.parent != nil {
+			s.parent.fail(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// finish writes the header of the stream, and the buffered elements
+// if any
+func (s *streamWriter) finish() error {
+	if s.err != nil {
+		return errors.Wrap(s.err, `msgpack: stream is incomplete due to a failed write`)
+	}
+
+	if uint64(s.count) > math.MaxUint32 {
+		return errors.Errorf(`msgpack: element count out of range (%d)`, s.count)
+	}
+
+	if s.patcher != nil {
+		return s.patchHeader()
+	}
+
+	var err error
+	if s.isMap {
+		err = WriteMapHeader(s.enl.dst, s.count)
+	} else {
+		err = WriteArrayHeader(s.enl.dst, s.count)
+	}
+	if err != nil {
+		return errors.Wrap(err, `msgpack: failed to write header`)
+	}
+	if err := s.spill.writeTo(s.enl.dst); err != nil {
+		return errors.Wrap(err, `msgpack: failed to write elements`)
+	}
+	return nil
+}
+
+// restore points the Encoder back at its previous destination
+func (s *streamWriter) restore() {
+	s.enl.dst, s.enl.buf, s.enl.patcher = s.prevDst, s.prevBuf, s.prevPatcher
+}
+
+// patchHeader writes the element count into the header that was
+// reserved when the stream began
+func (s *streamWriter) patchHeader() error {
+	enl := s.enl
+	if err := enl.Flush(); err != nil {
+		return err
+	}
+
+	b := enl.scratch[:4]
+	binary.BigEndian.PutUint32(b, uint32(s.count))
+	if _, err := s.patcher.WriteAt(b, s.offset+1); err != nil {
+		return errors.Wrap(err, `msgpack: failed to write element count`)
+	}
+	return nil
+}
+
+// patchOffset returns the current offset of w, if the bytes written
+// from there on can later be overwritten using WriteAt
+func patchOffset(w patchableWriter) (int64, bool) {
+	offset, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	// An empty write does not change anything, but fails if WriteAt
+	// is not usable
+	if _, err := w.WriteAt(nil, offset); err != nil {
+		return 0, false
+	}
+	return offset, true
+}
+
+func (s arrayStream) Add(v interface{}) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	if err := s.enl.Encode(v); err != nil {
+		return s.fail(errors.Wrapf(err, `msgpack: failed to encode array element %d`, s.count))
+	}
+	s.count++
+	return nil
+}
+
+func (s arrayStream) BeginArray() (ArrayWriter, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	w, err := s.enl.BeginArray()
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	s.count++
+	return w, nil
+}
+
+func (s arrayStream) BeginMap() (MapWriter, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	w, err := s.enl.BeginMap()
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	s.count++
+	return w, nil
+}
+
+func (s mapStream) Add(key, value interface{}) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	if err := s.enl.Encode(key); err != nil {
+		return s.fail(errors.Wrapf(err, `msgpack: failed to encode map key at index %d`, s.count))
+	}
+	if err := s.enl.Encode(value); err != nil {
+		return s.fail(errors.Wrapf(err, `msgpack: failed to encode map value for key %v`, key))
+	}
+	s.count++
+	return nil
+}
+
+func (s mapStream) BeginArray(key interface{}) (ArrayWriter, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	if err := s.enl.Encode(key); err != nil {
+		return nil, s.fail(errors.Wrapf(err, `msgpack: failed to encode map key at index %d`, s.count))
+	}
+	w, err := s.enl.BeginArray()
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	s.count++
+	return w, nil
+}
+
+func (s mapStream) BeginMap(key interface{}) (MapWriter, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	if err := s.enl.Encode(key); err != nil {
+		return nil, s.fail(errors.Wrapf(err, `msgpack: failed to encode map key at index %d`, s.count))
+	}
+	w, err := s.enl.BeginMap()
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	s.count++
+	return w, nil
+}
+
+// spillBuffer keeps data in memory until it exceeds the threshold,
+// and then moves it to a temporary file
+type spillBuffer struct {
+	threshold int
+	mem       []byte
+	file      *os.File
+	w         *bufio.Writer
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.file == nil {
+		if b.threshold <= 0 || len(b.mem)+len(p) <= b.threshold {
+			b.mem = append(b.mem, p...)
+			return len(p), nil
+		}
+
+		// os.CreateTemp requires Go 1.16, but go.mod declares 1.12
+		f, err := ioutil.TempFile("", "msgpack-stream-")
+		if err != nil {
+			return 0, errors.Wrap(err, `msgpack: failed to create temporary file`)
+		}
+		b.file = f
+		b.w = bufio.NewWriter(f)
+		if _, err := b.w.Write(b.mem); err != nil {
+			return 0, errors.Wrap(err, `msgpack: failed to write to temporary file`)
+		}
+		b.mem = nil
+	}
+	return b.w.Write(p)
+}
+
+// writeTo copies the contents of the buffer to dst
+func (b *spillBuffer) writeTo(dst io.Writer) error {
+	if b.file == nil {
+		_, err := dst.Write(b.mem)
+		return err
+	}
+
+	if err := b.w.Flush(); err != nil {
+		return errors.Wrap(err, `msgpack: failed to flush temporary file`)
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, `msgpack: failed to rewind temporary file`)
+	}
+	if _, err := io.Copy(dst, b.file); err != nil {
+		return errors.Wrap(err, `msgpack: failed to copy temporary file`)
+	}
+	return nil
+}
+
+// release frees the memory and the temporary file, if any
+func (b *spillBuffer) release() {
+	b.mem = nil
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+		b.file = nil
+		b.w = nil
+	}
+}
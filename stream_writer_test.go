@@ -0,0 +1,286 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeStream writes an array of n integers, followed by a nested map
+// and a nested array
+func writeStream(t *testing.T, enc msgpack.Encoder, n int) bool {
+	t.Helper()
+	a, err := enc.BeginArray()
+	if !assert.NoError(t, err, "BeginArray should succeed") {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if !assert.NoError(t, a.Add(i), "Add should succeed") {
+			return false
+		}
+	}
+
+	m, err := a.BeginMap()
+	if !assert.NoError(t, err, "BeginMap should succeed") {
+		return false
+	}
+	if !assert.NoError(t, m.Add("foo", "bar"), "Add should succeed") {
+		return false
+	}
+	nested, err := m.BeginArray("list")
+	if !assert.NoError(t, err, "BeginArray should succeed") {
+		return false
+	}
+	assert.Error(t, m.Add("baz", 1), "Add should fail while a nested array is open")
+	assert.Error(t, m.Close(), "Close should fail while a nested array is open")
+	if !assert.NoError(t, nested.Add(true), "Add should succeed") {
+		return false
+	}
+	if !assert.NoError(t, nested.Close(), "Close should succeed") {
+		return false
+	}
+	if !assert.NoError(t, m.Close(), "Close should succeed") {
+		return false
+	}
+	if !assert.NoError(t, a.Add(nil), "Add should succeed") {
+		return false
+	}
+	if !assert.Equal(t, n+2, a.Count(), "Count should match") {
+		return false
+	}
+	if !assert.NoError(t, a.Close(), "Close should succeed") {
+		return false
+	}
+	assert.Error(t, a.Close(), "Close should fail after the stream has been closed")
+	assert.Error(t, a.Add(1), "Add should fail after the stream has been closed")
+	return assert.NoError(t, enc.Flush(), "Flush should succeed")
+}
+
+func expectedStream(n int) []interface{} {
+	expected := make([]interface{}, 0, n+2)
+	for i := 0; i < n; i++ {
+		expected = append(expected, int64(i))
+	}
+	expected = append(expected, map[string]interface{}{"foo": "bar", "list": []interface{}{true}}, nil)
+	return expected
+}
+
+func checkStream(t *testing.T, b []byte, n int) {
+	t.Helper()
+	var v []interface{}
+	if !assert.NoError(t, msgpack.Unmarshal(b, &v, msgpack.WithNormalizeIntegers(true)), "Unmarshal should succeed") {
+		return
+	}
+	assert.Equal(t, expectedStream(n), v, "decoded value should match")
+}
+
+func TestStreamingEncoder(t *testing.T) {
+	t.Parallel()
+
+	t.Run("in memory", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		if !writeStream(t, msgpack.NewEncoder(&buf), 20) {
+			return
+		}
+		assert.Equal(t, msgpack.Array16.Byte(), buf.Bytes()[0], "smallest header should be used")
+		checkStream(t, buf.Bytes(), 20)
+	})
+	t.Run("spill to file", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		if !writeStream(t, msgpack.NewEncoder(&buf, msgpack.WithSpillThreshold(64)), 1000) {
+			return
+		}
+		checkStream(t, buf.Bytes(), 1000)
+	})
+	t.Run("seekable", func(t *testing.T) {
+		t.Parallel()
+		f, err := ioutil.TempFile("", "msgpack-test-")
+		if !assert.NoError(t, err, "TempFile should succeed") {
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		enc := msgpack.NewEncoder(f)
+		if !assert.NoError(t, enc.EncodeString("prefix"), "EncodeString should succeed") {
+			return
+		}
+		if !writeStream(t, enc, 20) {
+			return
+		}
+		if !assert.NoError(t, enc.EncodeString("suffix"), "EncodeString should succeed") {
+			return
+		}
+		if !assert.NoError(t, enc.Flush(), "Flush should succeed") {
+			return
+		}
+
+		b, err := ioutil.ReadFile(f.Name())
+		if !assert.NoError(t, err, "ReadFile should succeed") {
+			return
+		}
+
+		dec := msgpack.NewDecoderBytes(b, msgpack.WithNormalizeIntegers(true))
+		var s string
+		if !assert.NoError(t, dec.DecodeString(&s), "DecodeString should succeed") {
+			return
+		}
+		code, err := dec.PeekCode()
+		if !assert.NoError(t, err, "PeekCode should succeed") {
+			return
+		}
+		assert.Equal(t, msgpack.Array32, code, "header should be reserved")
+
+		var v []interface{}
+		if !assert.NoError(t, dec.Decode(&v), "Decode should succeed") {
+			return
+		}
+		assert.Equal(t, expectedStream(20), v, "decoded value should match")
+		if !assert.NoError(t, dec.DecodeString(&s), "DecodeString should succeed") {
+			return
+		}
+		assert.Equal(t, "suffix", s, "value after the stream should be decoded")
+	})
+	t.Run("append mode file", func(t *testing.T) {
+		t.Parallel()
+		f, err := ioutil.TempFile("", "msgpack-test-")
+		if !assert.NoError(t, err, "TempFile should succeed") {
+			return
+		}
+		defer os.Remove(f.Name())
+		f.Close()
+
+		// Writes to a file opened with O_APPEND always go to the end of
+		// the file, so the header can not be patched
+		f, err = os.OpenFile(f.Name(), os.O_WRONLY|os.O_APPEND, 0600)
+		if !assert.NoError(t, err, "OpenFile should succeed") {
+			return
+		}
+		defer f.Close()
+
+		enc := msgpack.NewEncoder(f)
+		if !writeStream(t, enc, 20) {
+			return
+		}
+		if !assert.NoError(t, enc.EncodeString("suffix"), "EncodeString should succeed") {
+			return
+		}
+		if !assert.NoError(t, enc.Flush(), "Flush should succeed") {
+			return
+		}
+
+		b, err := ioutil.ReadFile(f.Name())
+		if !assert.NoError(t, err, "ReadFile should succeed") {
+			return
+		}
+
+		dec := msgpack.NewDecoderBytes(b, msgpack.WithNormalizeIntegers(true))
+		var v []interface{}
+		if !assert.NoError(t, dec.Decode(&v), "Decode should succeed") {
+			return
+		}
+		assert.Equal(t, expectedStream(20), v, "decoded value should match")
+		var s string
+		if !assert.NoError(t, dec.DecodeString(&s), "DecodeString should succeed") {
+			return
+		}
+		assert.Equal(t, "suffix", s, "value after the stream should be decoded")
+	})
+	t.Run("canonical", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf, msgpack.WithCanonical(true))
+		if !writeStream(t, enc, 3) {
+			return
+		}
+		assert.Equal(t, byte(0x95), buf.Bytes()[0], "smallest header should be used")
+		checkStream(t, buf.Bytes(), 3)
+	})
+	t.Run("partially written element", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		a, err := enc.BeginArray()
+		if !assert.NoError(t, err, "BeginArray should succeed") {
+			return
+		}
+		if !assert.NoError(t, a.Add(1), "Add should succeed") {
+			return
+		}
+		// The list header is written before encoding the channel fails
+		assert.Error(t, a.Add([]interface{}{1, make(chan int)}), "Add should fail")
+		assert.Equal(t, 1, a.Count(), "failed elements should not be counted")
+		assert.Error(t, a.Add(2), "Add should fail after a partial write")
+		assert.Error(t, a.Close(), "Close should fail after a partial write")
+
+		// The Encoder is usable again after the stream has been closed
+		if !assert.NoError(t, enc.EncodeString("foo"), "EncodeString should succeed") {
+			return
+		}
+	})
+	t.Run("failed nested stream", func(t *testing.T) {
+		t.Parallel()
+		w := &failingPatchable{}
+		enc := msgpack.NewEncoder(w)
+		a, err := enc.BeginArray()
+		if !assert.NoError(t, err, "BeginArray should succeed") {
+			return
+		}
+		if !assert.NoError(t, a.Add(1), "Add should succeed") {
+			return
+		}
+
+		w.fail = true
+		_, err = a.BeginArray()
+		assert.Error(t, err, "BeginArray should fail")
+		assert.Equal(t, 1, a.Count(), "failed nested arrays should not be counted")
+		assert.Error(t, a.Close(), "Close should fail after a failed write")
+	})
+}
+
+// failingPatchable is a seekable destination whose writes fail when
+// fail is set
+type failingPatchable struct {
+	buf  []byte
+	pos  int64
+	fail bool
+}
+
+func (w *failingPatchable) Write(p []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New(`write failed`)
+	}
+	n, err := w.WriteAt(p, w.pos)
+	w.pos += int64(n)
+	return n, err
+}
+
+func (w *failingPatchable) WriteAt(p []byte, off int64) (int, error) {
+	if w.fail {
+		return 0, errors.New(`write failed`)
+	}
+	if end := off + int64(len(p)); end > int64(len(w.buf)) {
+		w.buf = append(w.buf, make([]byte, end-int64(len(w.buf)))...)
+	}
+	return copy(w.buf[off:], p), nil
+}
+
+func (w *failingPatchable) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		w.pos = offset
+	case io.SeekCurrent:
+		w.pos += offset
+	default:
+		w.pos = int64(len(w.buf)) + offset
+	}
+	return w.pos, nil
+}
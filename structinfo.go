@@ -0,0 +1,694 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// structInfo is the compiled encode/decode plan for a particular
+// struct type. It is computed once per type, and is then shared
+// by all Encoders and Decoders
+type structInfo struct {
+	fields []*structField
+	// byName maps field names and aliases to fields
+	byName map[string]*structField
+	// byFoldedName is like byName, but the keys are lower cased.
+	// It is used for case-insensitive matching
+	byFoldedName map[string]*structField
+	// hasOmit is true if at least one of the fields has the
+	// omitempty or omitzero flag, which means we need to count the
+	// number of fields before writing the map header
+	hasOmit bool
+	// asArray is true if the struct should be encoded as an array
+	// of field values instead of a map
+	asArray bool
+	// hasEmbedded is true if at least one of the fields is promoted
+	// from an embedded struct. Such fields may not be reachable if
+	// the embedded struct is a nil pointer
+	hasEmbedded bool
+	// sortedFields are the fields sorted by the bytes of their
+	// encoded keys, which is the order used in canonical mode
+	sortedFields []*structField
+}
+
+type structField struct {
+	name string
+	// index is the sequence of field indices to reach this field
+	// from the outer struct, as in reflect.Value.FieldByIndex. It
+	// has more than one element for fields promoted from embedded
+	// structs
+	index     []int
+	omitempty bool
+	// isZero is non-nil if the field has the omitzero flag
+	isZero func(reflect.Value) bool
+	// tagged is true if the name was explicitly given in the struct tag
+	tagged bool
+	// aliases are the extra names that this field may be decoded from
+	aliases []string
+	// key is the pre-encoded msgpack representation of name
+	key    []byte
+	encode func(*encoderNL, reflect.Value) error
+	decode func(*decoderNL, reflect.Value) error
+}
+
+var muStructInfo sync.RWMutex
+var structInfoRegistry = make(map[reflect.Type]*structInfo)
+
+// getStructInfo returns the compiled plan for the struct type t.
+// The first call for a given type compiles the plan, subsequent
+// calls return the cached value
+func getStructInfo(t reflect.Type) *structInfo {
+	muStructInfo.RLock()
+	info, ok := structInfoRegistry[t]
+	muStructInfo.RUnlock()
+	if ok {
+		return info
+	}
+
+	info = compileStructInfo(t)
+
+	muStructInfo.Lock()
+	// Somebody else may have beaten us to it. Use theirs so that
+	// everybody shares the same plan
+	if existing, ok := structInfoRegistry[t]; ok {
+		info = existing
+	} else {
+		structInfoRegistry[t] = info
+	}
+	muStructInfo.Unlock()
+	return info
+}
+
+// compileStructInfo collects the fields of t, including those promoted
+// from embedded structs. The rules are the same as those of encoding/json:
+// the exported fields of an embedded struct are treated as if they were
+// fields of the outer struct, unless the embedded field is given a name
+// in the struct tag. When multiple fields share the same name, the one
+// that is nested the shallowest wins, and a tagged field wins over an
+// untagged one at the same depth. If there is still a tie, all of the
+// conflicting fields are ignored
+func compileStructInfo(t reflect.Type) *structInfo {
+	info := &structInfo{
+		byName:       make(map[string]*structField),
+		byFoldedName: make(map[string]*structField),
+	}
+
+	// Struct level options are specified on a blank field
+	for i := 0; i < t.NumField(); i++ {
+		if ft := t.Field(i); ft.Name == "_" {
+			if _, opts := parseMsgpackTag(ft); opts.toarray {
+				info.asArray = true
+			}
+		}
+	}
+
+	type queued struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var candidates []*structField
+	current := []queued{}
+	next := []queued{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		for _, q := range current {
+			// Types seen at a shallower depth are not processed again.
+			// A type embedded more than once at the same depth is
+			// processed each time, so that its fields conflict
+			if visited[q.typ] {
+				continue
+			}
+
+			for i := 0; i < q.typ.NumField(); i++ {
+				ft := q.typ.Field(i)
+				if ft.Name == "_" {
+					continue
+				}
+
+				if ft.Anonymous {
+					et := ft.Type
+					if et.Kind() == reflect.Ptr {
+						et = et.Elem()
+					}
+					// Embedded fields of unexported non-struct types
+					// cannot be accessed
+					if ft.PkgPath != "" && et.Kind() != reflect.Struct {
+						continue
+					}
+				} else if ft.PkgPath != "" {
+					continue
+				}
+
+				name, opts := parseMsgpackTag(ft)
+				if name == "-" {
+					continue
+				}
+				tagged := opts.named
+
+				index := make([]int, len(q.index)+1)
+				copy(index, q.index)
+				index[len(q.index)] = i
+
+				// Embedded structs without a name in the tag are
+				// flattened into the outer struct
+				if ft.Anonymous && !tagged {
+					if et, ok := flattenableStruct(ft.Type); ok {
+						next = append(next, queued{typ: et, index: index})
+						continue
+					}
+				}
+
+				candidates = append(candidates, &structField{
+					name:      name,
+					index:     index,
+					omitempty: opts.omitempty,
+					isZero:    compileIsZero(ft.Type, opts.omitzero),
+					tagged:    tagged,
+					aliases:   opts.aliases,
+					encode:    compileFieldEncoder(ft.Type),
+					decode:    compileFieldDecoder(ft.Type),
+				})
+			}
+		}
+
+		for _, q := range current {
+			visited[q.typ] = true
+		}
+	}
+
+	// Pick the dominant field for each name. Candidates are ordered by
+	// depth, so the first ones seen for a given name are the shallowest
+	byName := map[string][]*structField{}
+	var names []string
+	for _, field := range candidates {
+		if _, ok := byName[field.name]; !ok {
+			names = append(names, field.name)
+		}
+		byName[field.name] = append(byName[field.name], field)
+	}
+
+	var fields []*structField
+	for _, name := range names {
+		if field, ok := dominantField(byName[name]); ok {
+			fields = append(fields, field)
+		}
+	}
+
+	// Fields are encoded in the order that they are declared
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i].index, fields[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	for _, field := range fields {
+		w := newAppendingWriter(len(field.name) + 5)
+		// writing to an appendingWriter never fails
+		_ = NewEncoderNoLock(w).EncodeString(field.name)
+		field.key = w.Bytes()
+
+		if field.omitempty || field.isZero != nil {
+			info.hasOmit = true
+		}
+		if len(field.index) > 1 {
+			info.hasEmbedded = true
+		}
+		info.fields = append(info.fields, field)
+		info.byName[field.name] = field
+	}
+
+	info.sortedFields = make([]*structField, len(info.fields))
+	copy(info.sortedFields, info.fields)
+	sort.Slice(info.sortedFields, func(i, j int) bool {
+		return bytes.Compare(info.sortedFields[i].key, info.sortedFields[j].key) < 0
+	})
+
+	// Aliases never take precedence over actual field names, and
+	// when folding, the first field in declaration order wins
+	for _, field := range info.fields {
+		for _, alias := range field.aliases {
+			if _, ok := info.byName[alias]; !ok {
+				info.byName[alias] = field
+			}
+		}
+	}
+	for _, field := range info.fields {
+		folded := strings.ToLower(field.name)
+		if _, ok := info.byFoldedName[folded]; !ok {
+			info.byFoldedName[folded] = field
+		}
+	}
+	for _, field := range info.fields {
+		for _, alias := range field.aliases {
+			folded := strings.ToLower(alias)
+			if _, ok := info.byFoldedName[folded]; !ok {
+				info.byFoldedName[folded] = field
+			}
+		}
+	}
+	return info
+}
+
+// lookupFolded looks up a field by name, ignoring case. ASCII keys are
+// lower cased into a stack buffer, so that mismatched or unknown keys
+// do not cause an allocation
+func (info *structInfo) lookupFolded(key string) (*structField, bool) {
+	var buf [64]byte
+	folded := buf[:0]
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c >= utf8.RuneSelf {
+			field, ok := info.byFoldedName[strings.ToLower(key)]
+			return field, ok
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		folded = append(folded, c)
+	}
+	field, ok := info.byFoldedName[string(folded)]
+	return field, ok
+}
+
+// omit returns true if the field value fv should be omitted
+// because of the omitempty or omitzero flags
+func (f *structField) omit(fv reflect.Value) bool {
+	if f.omitempty && isEmptyValue(fv) {
+		return true
+	}
+	return f.isZero != nil && f.isZero(fv)
+}
+
+type isZeroer interface {
+	IsZero() bool
+}
+
+var isZeroerType = reflect.TypeOf((*isZeroer)(nil)).Elem()
+
+// compileIsZero returns the function used to check values of type t
+// for the omitzero flag. If t has an IsZero() bool method, it is used.
+// Otherwise the value is compared against the zero value of t
+func compileIsZero(t reflect.Type, omitzero bool) func(reflect.Value) bool {
+	if !omitzero {
+		return nil
+	}
+
+	switch {
+	case t.Implements(isZeroerType):
+		return func(rv reflect.Value) bool {
+			// Calling IsZero on a nil pointer would panic
+			if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) && rv.IsNil() {
+				return true
+			}
+			return rv.Interface().(isZeroer).IsZero()
+		}
+	case reflect.PtrTo(t).Implements(isZeroerType):
+		return func(rv reflect.Value) bool {
+			if !rv.CanAddr() {
+				// Copy the value so that we can take its address
+				tmp := reflect.New(t).Elem()
+				tmp.Set(rv)
+				rv = tmp
+			}
+			return rv.Addr().Interface().(isZeroer).IsZero()
+		}
+	}
+	return func(rv reflect.Value) bool {
+		return rv.IsZero()
+	}
+}
+
+// flattenableStruct returns the struct type to flatten for an embedded
+// field of type t. Structs that handle their own serialization are
+// not flattened, and are treated as regular fields
+func flattenableStruct(t reflect.Type) (reflect.Type, bool) {
+	if hasCustomEncoder(t) || hasCustomDecoder(t) {
+		return nil, false
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil, false
+	}
+	return t, true
+}
+
+// dominantField returns the field that wins among the fields sharing
+// the same name, which are ordered by depth. ok is false if there is
+// no single winner
+func dominantField(fields []*structField) (*structField, bool) {
+	depth := len(fields[0].index)
+	var dominant *structField
+	var ambiguous bool
+	for _, field := range fields {
+		if len(field.index) > depth {
+			break
+		}
+
+		switch {
+		case dominant == nil:
+			dominant = field
+		case field.tagged && !dominant.tagged:
+			dominant = field
+			ambiguous = false
+		case field.tagged == dominant.tagged:
+			ambiguous = true
+		}
+	}
+	if ambiguous {
+		return nil, false
+	}
+	return dominant, true
+}
+
+// fieldByIndex returns the field of the struct rv pointed to by the
+// field's index. ok is false if the field is not reachable because of
+// a nil embedded pointer
+func (f *structField) fieldByIndex(rv reflect.Value) (reflect.Value, bool) {
+	if len(f.index) == 1 {
+		return rv.Field(f.index[0]), true
+	}
+
+	for i, x := range f.index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+// fieldByIndexAlloc is like fieldByIndex, but allocates nil embedded
+// pointers along the way
+func (f *structField) fieldByIndexAlloc(rv reflect.Value) (reflect.Value, error) {
+	if len(f.index) == 1 {
+		return rv.Field(f.index[0]), nil
+	}
+
+	for i, x := range f.index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				if !rv.CanSet() {
+					return reflect.Value{}, errors.Errorf(`msgpack: cannot set embedded pointer to unexported struct %s`, rv.Type().Elem())
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, nil
+}
+
+// hasCustomEncoder returns true if values of type t need to go through
+// the extension / EncodeMsgpacker / marshaler machinery in Encode
+func hasCustomEncoder(t reflect.Type) bool {
+	return isExtType(t) || isEncodeMsgpacker(t) || isMarshaler(t)
+}
+
+func hasCustomDecoder(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return isDecodeMsgpacker(pt) || isUnmarshaler(pt)
+}
+
+var byteSliceType = reflect.TypeOf([]byte(nil))
+var timeType = reflect.TypeOf(time.Time{})
+var timePtrType = reflect.PtrTo(timeType)
+
+func compileFieldEncoder(t reflect.Type) func(*encoderNL, reflect.Value) error {
+	if hasCustomEncoder(t) {
+		return encodeFieldGeneric
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeString(rv.String())
+		}
+	case reflect.Bool:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeBool(rv.Bool())
+		}
+	case reflect.Int, reflect.Int64:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeInt64(rv.Int())
+		}
+	case reflect.Int8:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeInt8(int8(rv.Int()))
+		}
+	case reflect.Int16:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeInt16(int16(rv.Int()))
+		}
+	case reflect.Int32:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeInt32(int32(rv.Int()))
+		}
+	case reflect.Uint, reflect.Uint64:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeUint64(rv.Uint())
+		}
+	case reflect.Uint8:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeUint8(uint8(rv.Uint()))
+		}
+	case reflect.Uint16:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeUint16(uint16(rv.Uint()))
+		}
+	case reflect.Uint32:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeUint32(uint32(rv.Uint()))
+		}
+	case reflect.Float32:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeFloat32(float32(rv.Float()))
+		}
+	case reflect.Float64:
+		return func(enl *encoderNL, rv reflect.Value) error {
+			return enl.EncodeFloat64(rv.Float())
+		}
+	case reflect.Slice:
+		if t == byteSliceType {
+			return func(enl *encoderNL, rv reflect.Value) error {
+				return enl.EncodeBytes(rv.Bytes())
+			}
+		}
+	}
+	return encodeFieldGeneric
+}
+
+func encodeFieldGeneric(enl *encoderNL, rv reflect.Value) error {
+	return enl.Encode(rv.Interface())
+}
+
+func compileFieldDecoder(t reflect.Type) func(*decoderNL, reflect.Value) error {
+	// Named types (type Foo int) go through the generic path, as
+	// that is more lenient about the wire type it accepts
+	if t.PkgPath() != "" || hasCustomDecoder(t) {
+		return decodeFieldGeneric
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var s string
+			if err := dnl.DecodeString(&s); err != nil {
+				return err
+			}
+			rv.SetString(s)
+			return nil
+		}
+	case reflect.Bool:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var b bool
+			if err := dnl.DecodeBool(&b); err != nil {
+				return err
+			}
+			rv.SetBool(b)
+			return nil
+		}
+	case reflect.Int:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x int
+			if err := dnl.DecodeInt(&x); err != nil {
+				return err
+			}
+			rv.SetInt(int64(x))
+			return nil
+		}
+	case reflect.Int8:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x int8
+			if err := dnl.DecodeInt8(&x); err != nil {
+				return err
+			}
+			rv.SetInt(int64(x))
+			return nil
+		}
+	case reflect.Int16:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x int16
+			if err := dnl.DecodeInt16(&x); err != nil {
+				return err
+			}
+			rv.SetInt(int64(x))
+			return nil
+		}
+	case reflect.Int32:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x int32
+			if err := dnl.DecodeInt32(&x); err != nil {
+				return err
+			}
+			rv.SetInt(int64(x))
+			return nil
+		}
+	case reflect.Int64:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x int64
+			if err := dnl.DecodeInt64(&x); err != nil {
+				return err
+			}
+			rv.SetInt(x)
+			return nil
+		}
+	case reflect.Uint:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x uint
+			if err := dnl.DecodeUint(&x); err != nil {
+				return err
+			}
+			rv.SetUint(uint64(x))
+			return nil
+		}
+	case reflect.Uint8:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x uint8
+			if err := dnl.DecodeUint8(&x); err != nil {
+				return err
+			}
+			rv.SetUint(uint64(x))
+			return nil
+		}
+	case reflect.Uint16:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x uint16
+			if err := dnl.DecodeUint16(&x); err != nil {
+				return err
+			}
+			rv.SetUint(uint64(x))
+			return nil
+		}
+	case reflect.Uint32:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x uint32
+			if err := dnl.DecodeUint32(&x); err != nil {
+				return err
+			}
+			rv.SetUint(uint64(x))
+			return nil
+		}
+	case reflect.Uint64:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x uint64
+			if err := dnl.DecodeUint64(&x); err != nil {
+				return err
+			}
+			rv.SetUint(x)
+			return nil
+		}
+	case reflect.Float32:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x float32
+			if err := dnl.DecodeFloat32(&x); err != nil {
+				return err
+			}
+			rv.SetFloat(float64(x))
+			return nil
+		}
+	case reflect.Float64:
+		return func(dnl *decoderNL, rv reflect.Value) error {
+			var x float64
+			if err := dnl.DecodeFloat64(&x); err != nil {
+				return err
+			}
+			rv.SetFloat(x)
+			return nil
+		}
+	case reflect.Slice:
+		if t == byteSliceType {
+			return func(dnl *decoderNL, rv reflect.Value) error {
+				var b []byte
+				if err := dnl.DecodeBytes(&b); err != nil {
+					return err
+				}
+				rv.SetBytes(b)
+				return nil
+			}
+		}
+	}
+	return decodeFieldGeneric
+}
+
+// decodeFieldGeneric is the catch-all field decoder, used for
+// types that do not have a specialized decoder
+func decodeFieldGeneric(dnl *decoderNL, f reflect.Value) error {
+	switch {
+	case f.Kind() == reflect.Slice:
+		r := reflect.New(f.Type()).Elem()
+		if err := dnl.Decode(r.Addr().Interface()); err != nil {
+			return errors.Wrap(err, `failed to decode slice value`)
+		}
+		f.Set(r)
+	case f.Kind() == reflect.Struct:
+		if err := dnl.Decode(f.Addr().Interface()); err != nil {
+			return errors.Wrap(err, `failed to decode struct value (struct)`)
+		}
+	case f.Kind() == reflect.Map:
+		// decode directly into the field, so that an existing map is reused
+		if err := dnl.Decode(f.Addr().Interface()); err != nil {
+			return errors.Wrap(err, `failed to decode map value`)
+		}
+	case f.Kind() == reflect.Ptr && f.Type().Elem().Kind() == reflect.Struct:
+		r := reflect.New(f.Type().Elem())
+		if err := dnl.Decode(r.Interface()); err != nil {
+			return errors.Wrap(err, `failed to decode struct value (pointer to struct)`)
+		}
+		f.Set(r)
+	default:
+		var fv reflect.Value
+		if f.Kind() == reflect.Ptr {
+			fv = reflect.New(f.Type().Elem())
+		} else {
+			fv = reflect.New(f.Type())
+		}
+		if err := dnl.Decode(fv.Interface()); err != nil {
+			return errors.Wrap(err, `failed to decode struct value (not struct/pointer to struct)`)
+		}
+
+		if err := assignIfCompatible(f, fv.Elem()); err != nil {
+			return errors.Wrap(err, `failed to assign struct value`)
+		}
+	}
+	return nil
+}
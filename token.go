@@ -0,0 +1,169 @@
+package msgpack
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// TokenKind identifies the type of a Token
+type TokenKind int
+
+const (
+	// TokenNil is a nil value
+	TokenNil TokenKind = iota + 1
+	// TokenBool is a boolean, stored in Token.Bool
+	TokenBool
+	// TokenInt is a signed integer, stored in Token.Int. Positive
+	// fixnums are reported as TokenInt as well
+	TokenInt
+	// TokenUint is an unsigned integer, stored in Token.Uint
+	TokenUint
+	// TokenFloat is a float32 or a float64, stored in Token.Float
+	TokenFloat
+	// TokenString is a str, stored in Token.String
+	TokenString
+	// TokenBin is a bin, stored in Token.Bytes
+	TokenBin
+	// TokenArray is the start of an array of Token.Length elements
+	TokenArray
+	// TokenMap is the start of a map of Token.Length key/value pairs
+	TokenMap
+	// TokenExt is an extension of type Token.ExtType, with its payload
+	// stored in Token.Bytes
+	TokenExt
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenNil:
+		return "nil"
+	case TokenBool:
+		return "bool"
+	case TokenInt:
+		return "int"
+	case TokenUint:
+		return "uint"
+	case TokenFloat:
+		return "float"
+	case TokenString:
+		return "string"
+	case TokenBin:
+		return "bin"
+	case TokenArray:
+		return "array"
+	case TokenMap:
+		return "map"
+	case TokenExt:
+		return "ext"
+	}
+	return "TokenKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Token is a single msgpack value, as returned by Decoder.Token.
+// Arrays and maps are returned as a token that holds the number of
+// elements, which are then returned as separate tokens. Only the fields
+// that are relevant to the Kind are set
+type Token struct {
+	Kind TokenKind
+	// Code is the msgpack code that the token was decoded from
+	Code Code
+	// Offset is the position of the token in the source, in bytes
+	Offset int64
+	// Length is the number of elements for TokenArray, the number of
+	// key/value pairs for TokenMap, and the length of the payload for
+	// TokenString, TokenBin and TokenExt
+	Length int
+
+	Bool  bool
+	Int   int64
+	Uint  uint64
+	Float float64
+	// String is the value of a TokenString
+	String string
+	// Bytes is the value of a TokenBin, or the payload of a TokenExt
+	Bytes []byte
+	// ExtType is the extension type of a TokenExt
+	ExtType int8
+}
+
+// Token returns the next token in the stream, without materializing
+// whole arrays or maps. Unlike encoding/json.Decoder.Token, the nesting
+// of arrays and maps is not tracked: after an array or a map token,
+// the caller is responsible for reading Length elements (or 2 * Length
+// keys and values). Token calls can be freely mixed with Decode and
+// Skip, for example to decode the elements of a large array one by one.
+//
+// At the end of the stream, Token returns io.EOF
+func (dnl *decoderNL) Token() (Token, error) {
+	tok := Token{Offset: dnl.InputOffset()}
+
+	code, err := dnl.PeekCode()
+	if err != nil {
+		if errors.Cause(err) == io.EOF {
+			return tok, io.EOF
+		}
+		return tok, err
+	}
+	tok.Code = code
+
+	switch {
+	case code == Nil:
+		tok.Kind = TokenNil
+		_, err = dnl.ReadCode()
+	case code == True || code == False:
+		tok.Kind = TokenBool
+		err = dnl.DecodeBool(&tok.Bool)
+	case IsNumberFamily(code):
+		var n Number
+		if err := n.DecodeMsgpack(dnl); err != nil {
+			return tok, errors.Wrap(err, `msgpack: failed to decode number token`)
+		}
+		switch v := n.normalize().(type) {
+		case int64:
+			tok.Kind = TokenInt
+			tok.Int = v
+		case uint64:
+			tok.Kind = TokenUint
+			tok.Uint = v
+		default:
+			tok.Kind = TokenFloat
+			tok.Float, _ = n.Float64()
+		}
+	case IsStrFamily(code):
+		tok.Kind = TokenString
+		err = dnl.DecodeString(&tok.String)
+		tok.Length = len(tok.String)
+	case IsBinFamily(code):
+		tok.Kind = TokenBin
+		err = dnl.DecodeBytes(&tok.Bytes)
+		tok.Length = len(tok.Bytes)
+	case IsArrayFamily(code):
+		tok.Kind = TokenArray
+		err = dnl.DecodeArrayLength(&tok.Length)
+	case IsMapFamily(code):
+		tok.Kind = TokenMap
+		err = dnl.DecodeMapLength(&tok.Length)
+	case IsExtFamily(code):
+		tok.Kind = TokenExt
+		if err := dnl.DecodeExtLength(&tok.Length); err != nil {
+			return tok, errors.Wrap(err, `msgpack: failed to decode ext length`)
+		}
+		typ, err := dnl.src.ReadByte()
+		if err != nil {
+			return tok, errors.Wrap(err, `msgpack: failed to read ext type`)
+		}
+		tok.ExtType = int8(typ)
+		if tok.Bytes, err = dnl.readPayload(tok.Length); err != nil {
+			return tok, errors.Wrap(err, `msgpack: failed to read ext payload`)
+		}
+	default:
+		return tok, errors.Errorf(`msgpack: invalid code %s at offset %d`, code, tok.Offset)
+	}
+
+	if err != nil {
+		return tok, errors.Wrapf(err, `msgpack: failed to decode %s token`, tok.Kind)
+	}
+	return tok, nil
+}
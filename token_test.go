@@ -0,0 +1,116 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+
+	msgpack "github.com/lestrrat-go/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoderToken(t *testing.T) {
+	t.Parallel()
+
+	var b []byte
+	var offsets []int64
+	mark := func() { offsets = append(offsets, int64(len(b))) }
+
+	mark()
+	b = msgpack.AppendMapHeader(b, 1)
+	mark()
+	b = msgpack.AppendString(b, "list")
+	mark()
+	b = msgpack.AppendArrayHeader(b, 3)
+	mark()
+	b = msgpack.AppendInt8(b, -100)
+	mark()
+	b = msgpack.AppendUint64(b, math.MaxUint64)
+	mark()
+	b = msgpack.AppendFloat64(b, 3.5)
+	mark()
+	b = msgpack.AppendBytes(b, []byte("bin"))
+	mark()
+	b = msgpack.AppendNil(b)
+	mark()
+	b = msgpack.AppendBool(b, true)
+	mark()
+	b = msgpack.AppendExt(b, 42, []byte("payload"))
+	mark()
+	b = msgpack.AppendUint8(b, 5)
+
+	expected := []msgpack.Token{
+		{Kind: msgpack.TokenMap, Code: msgpack.FixMap1, Length: 1},
+		{Kind: msgpack.TokenString, Code: msgpack.Code(0xa4), Length: 4, String: "list"},
+		{Kind: msgpack.TokenArray, Code: msgpack.FixArray3, Length: 3},
+		{Kind: msgpack.TokenInt, Code: msgpack.Int8, Int: -100},
+		{Kind: msgpack.TokenUint, Code: msgpack.Uint64, Uint: math.MaxUint64},
+		{Kind: msgpack.TokenFloat, Code: msgpack.Double, Float: 3.5},
+		{Kind: msgpack.TokenBin, Code: msgpack.Bin8, Length: 3, Bytes: []byte("bin")},
+		{Kind: msgpack.TokenNil, Code: msgpack.Nil},
+		{Kind: msgpack.TokenBool, Code: msgpack.True, Bool: true},
+		{Kind: msgpack.TokenExt, Code: msgpack.Ext8, Length: 7, ExtType: 42, Bytes: []byte("payload")},
+		{Kind: msgpack.TokenInt, Code: msgpack.Code(0x05), Int: 5},
+	}
+	for i := range expected {
+		expected[i].Offset = offsets[i]
+	}
+
+	decoders := map[string]msgpack.Decoder{
+		"io.Reader":  msgpack.NewDecoder(bytes.NewReader(b)),
+		"byte slice": msgpack.NewDecoderBytes(b),
+	}
+	for name, dec := range decoders {
+		dec := dec
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			for i, want := range expected {
+				tok, err := dec.Token()
+				if !assert.NoError(t, err, "Token %d should succeed", i) {
+					return
+				}
+				if !assert.Equal(t, want, tok, "Token %d should match", i) {
+					return
+				}
+			}
+			assert.Equal(t, int64(len(b)), dec.InputOffset(), "all input should be consumed")
+
+			_, err := dec.Token()
+			assert.Equal(t, io.EOF, err, "Token should return io.EOF at the end of the stream")
+		})
+	}
+
+	t.Run("mixed with Decode", func(t *testing.T) {
+		t.Parallel()
+		data, err := msgpack.Marshal([]interface{}{"foo", map[string]int{"bar": 1}, []int{1, 2}})
+		if !assert.NoError(t, err, "Marshal should succeed") {
+			return
+		}
+
+		dec := msgpack.NewDecoder(bytes.NewReader(data))
+		tok, err := dec.Token()
+		if !assert.NoError(t, err, "Token should succeed") {
+			return
+		}
+		if !assert.Equal(t, msgpack.TokenArray, tok.Kind, "Kind should match") {
+			return
+		}
+
+		var values []interface{}
+		for i := 0; i < tok.Length; i++ {
+			var v interface{}
+			if !assert.NoError(t, dec.Decode(&v), "Decode should succeed") {
+				return
+			}
+			values = append(values, v)
+		}
+		assert.Len(t, values, 3, "all elements should be decoded")
+		assert.Equal(t, int64(len(data)), dec.InputOffset(), "all input should be consumed")
+	})
+	t.Run("invalid code", func(t *testing.T) {
+		t.Parallel()
+		_, err := msgpack.NewDecoderBytes([]byte{0xc1}).Token()
+		assert.Error(t, err, "Token should fail")
+	})
+}